@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Git backends, picked with Config.Backend. libgit2 is linked in through
+// git2go, git runs the git binary.
+const (
+	BackendLibgit2 = "libgit2"
+	BackendGit     = "git"
+)
+
+// defaultGitTimeout bounds each git command run by the git backend when
+// GitTimeout is not set.
+const defaultGitTimeout = 2 * time.Minute
+
+// Pull strategies for when local master and origin/master have diverged.
+const (
+	StrategyMerge  = "merge"
+	StrategyRebase = "rebase"
+)
+
+// backends holds the backends built into this binary. libgit2 needs cgo and
+// registers itself when it is built in.
+var backends = map[string]GitBackend{
+	BackendGit: execBackend{},
+}
+
+// GitBackend opens repositories with one git implementation.
+type GitBackend interface {
+	Open(conf *Config, path string) (GitRepo, error)
+}
+
+// GitRepo is every git operation the bot performs on a checkout. They all
+// work on master and origin/master. Operations that update the checkout
+// never overwrite uncommitted changes, and one that fails with a
+// *ConflictError leaves the repository as it was.
+type GitRepo interface {
+	// AddAll stages every change in the working tree but the files in
+	// exclude, which are not even read.
+	AddAll(exclude []string) error
+	// Commit commits the index onto master and returns the new commit id.
+	Commit(message string, author, committer *Signature) (string, error)
+	Fetch() error
+	// Analyze compares master with the last fetched origin/master.
+	Analyze() (Analysis, error)
+	// AheadBehind counts the commits master has that the last fetched
+	// origin/master does not, and the other way around.
+	AheadBehind() (int, int, error)
+	// FastForward moves master to origin/master and returns its id.
+	FastForward() (string, error)
+	// Merge merges origin/master into master with a merge commit.
+	Merge(message string, author, committer *Signature) (string, error)
+	// Rebase replays the local commits onto origin/master.
+	Rebase(committer *Signature) (string, error)
+	// Squash replaces the local commits with one commit of the same tree.
+	Squash(message string, author, committer *Signature) (string, error)
+	// LocalCommits lists the commits on master that are not on
+	// origin/master, newest first.
+	LocalCommits() ([]CommitInfo, error)
+	// Untrack removes a file from the index, leaving the working tree be.
+	Untrack(path string) error
+	// Unstage puts a file in the index back the way it is in HEAD.
+	Unstage(path string) error
+	// RevParse returns the commit id rev points at.
+	RevParse(rev string) (string, error)
+	// ChangedFiles lists the files that differ between two revisions.
+	ChangedFiles(from, to string) ([]string, error)
+	// CommitObject returns the raw commit object rev points at.
+	CommitObject(rev string) ([]byte, error)
+	// DirtyFiles lists the files that are modified, staged or untracked.
+	DirtyFiles() ([]string, error)
+	// FileLog lists the commits reachable from master that changed path,
+	// newest first.
+	FileLog(path string) ([]string, error)
+	// FileAt returns the contents of path as of rev.
+	FileAt(rev, path string) ([]byte, error)
+	// Diff is the patch from path as of rev to the working tree.
+	Diff(rev, path string) (string, error)
+	Push() error
+}
+
+// Analysis is how master relates to origin/master.
+type Analysis int
+
+const (
+	// UpToDate means master already has everything on origin/master
+	UpToDate Analysis = iota
+	// Behind means master can be fast-forwarded to origin/master
+	Behind
+	// Diverged means both have commits the other does not
+	Diverged
+)
+
+// Signature is the name, email and time recorded on a commit.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// CommitInfo describes a commit listed by GitRepo.LocalCommits.
+type CommitInfo struct {
+	Id      string
+	Summary string
+	Author  Signature
+	Parents int
+}
+
+// ConflictError is returned when local and remote changes to a repository
+// cannot be combined automatically. The repository is left as it was.
+type ConflictError struct {
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflicting changes to %s", strings.Join(e.Paths, ", "))
+}
+
+// RejectedError is returned by Push when origin has commits that master does
+// not, so the push would not fast-forward.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("push rejected: %s", e.Reason)
+}
+
+// nonFastForward reports whether a push failure means origin has commits
+// master does not, as opposed to e.g. a hook on the remote declining it.
+func nonFastForward(reason string) bool {
+	reason = strings.ToLower(reason)
+	for _, s := range []string{"non-fast-forward", "non-fastforward", "fetch first"} {
+		if strings.Contains(reason, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backend returns the configured backend, by default libgit2 when it is
+// built in and git otherwise. It is nil for a backend this binary lacks.
+func (c *Config) backend() GitBackend {
+	if c.Backend != "" {
+		return backends[c.Backend]
+	}
+	if backend, ok := backends[BackendLibgit2]; ok {
+		return backend
+	}
+
+	return backends[BackendGit]
+}
+
+func (c *Config) pullStrategy() string {
+	if c.PullStrategy == "" {
+		return StrategyMerge
+	}
+
+	return c.PullStrategy
+}
+
+func (c *Config) gitTimeout() time.Duration {
+	timeout, err := time.ParseDuration(c.GitTimeout)
+	if err != nil {
+		return defaultGitTimeout
+	}
+
+	return timeout
+}
+
+// firstLine is the summary of a commit message, which is all of it that goes
+// in the reflog, the same as git commit does.
+func firstLine(message string) string {
+	line, _, _ := strings.Cut(message, "\n")
+	return line
+}
+
+// fastForwardAction is the reflog action of a fast-forward to id.
+func fastForwardAction(id string) string {
+	return "gistbot: fast-forward to " + id
+}
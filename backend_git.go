@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execBackend runs the git binary. It needs nothing but git on the PATH,
+// which makes the bot easy to build without cgo, and since it is plain git
+// the user's gitconfig applies: credential helpers, signing, hooks and url
+// rewrites all work as they do on the command line.
+type execBackend struct{}
+
+func (execBackend) Open(conf *Config, path string) (GitRepo, error) {
+	repo := &execRepo{conf: conf, path: path}
+
+	out, err := repo.git(nil, "rev-parse", "--is-inside-work-tree")
+	if err != nil || out != "true" {
+		return nil, fmt.Errorf("unable to create repository: %s is not a git work tree", path)
+	}
+
+	repo.env = []string{"GIT_TERMINAL_PROMPT=0"}
+	if conf.PrivateKey != "" && !repo.hasSSHCommand() {
+		repo.env = append(repo.env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes", conf.PrivateKey))
+	}
+
+	return repo, nil
+}
+
+type execRepo struct {
+	conf *Config
+	path string
+	// env is added to the environment of every git command
+	env []string
+}
+
+func (r *execRepo) AddAll(exclude []string) error {
+	args := []string{"add", "--all"}
+	if len(exclude) > 0 {
+		args = append(args, "--", ".")
+		for _, path := range exclude {
+			args = append(args, ":(exclude,literal)"+path)
+		}
+	}
+
+	_, err := r.git(nil, args...)
+	return err
+}
+
+func (r *execRepo) Commit(message string, author, committer *Signature) (string, error) {
+	// Match libgit2, which commits whether or not anything changed
+	if _, err := r.git(signatureEnv(author, committer), "commit", "--quiet", "--allow-empty", "--message", message); err != nil {
+		return "", err
+	}
+
+	return r.git(nil, "rev-parse", "HEAD")
+}
+
+func (r *execRepo) Push() error {
+	_, err := r.git(nil, "push", "--quiet", "origin", "refs/heads/master")
+	if err != nil && strings.Contains(err.Error(), "[rejected]") {
+		return &RejectedError{Reason: err.Error()}
+	}
+
+	return err
+}
+
+func (r *execRepo) Fetch() error {
+	_, err := r.git(nil, "fetch", "--quiet", "origin")
+	return err
+}
+
+func (r *execRepo) Analyze() (Analysis, error) {
+	ahead, behind, err := r.AheadBehind()
+	if err != nil {
+		return UpToDate, err
+	}
+
+	switch {
+	case behind == 0:
+		return UpToDate, nil
+	case ahead == 0:
+		return Behind, nil
+	}
+
+	return Diverged, nil
+}
+
+func (r *execRepo) AheadBehind() (int, int, error) {
+	out, err := r.git(nil, "rev-list", "--left-right", "--count", "master...origin/master")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var ahead, behind int
+	if _, err = fmt.Sscan(out, &ahead, &behind); err != nil {
+		return 0, 0, fmt.Errorf("error reading rev-list output %q: %v", out, err)
+	}
+
+	return ahead, behind, nil
+}
+
+// FastForward pulls with --ff-only, so pull config and hooks apply. git
+// appends ": Fast-forward" to the reflog action, which the libgit2 backend
+// copies so both log the same.
+func (r *execRepo) FastForward() (string, error) {
+	// pull --ff-only would just as well move whatever branch is checked out
+	head, err := r.git(nil, "symbolic-ref", "--quiet", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("HEAD is not on a branch: %v", err)
+	}
+	if head != "refs/heads/master" {
+		return "", fmt.Errorf("HEAD is on %s, not refs/heads/master", head)
+	}
+
+	// origin was just fetched, so this is where the pull takes master
+	target, err := r.git(nil, "rev-parse", "--verify", "refs/remotes/origin/master^{commit}")
+	if err != nil {
+		return "", err
+	}
+
+	env := []string{"GIT_REFLOG_ACTION=" + fastForwardAction(target)}
+	if _, err = r.git(env, "pull", "--quiet", "--ff-only", "origin", "master"); err != nil {
+		return "", err
+	}
+
+	return r.git(nil, "rev-parse", "HEAD")
+}
+
+func (r *execRepo) Merge(message string, author, committer *Signature) (string, error) {
+	env := append(signatureEnv(author, committer), "GIT_REFLOG_ACTION=gistbot: merge")
+	if _, err := r.git(env, "merge", "--quiet", "--no-edit", "--message", message, "origin/master"); err != nil {
+		return "", r.abort(err, "merge")
+	}
+
+	return r.git(nil, "rev-parse", "HEAD")
+}
+
+func (r *execRepo) Rebase(committer *Signature) (string, error) {
+	env := append(signatureEnv(nil, committer), "GIT_REFLOG_ACTION=gistbot: rebase")
+	if _, err := r.git(env, "pull", "--quiet", "--rebase", "origin", "master"); err != nil {
+		return "", r.abort(err, "rebase")
+	}
+
+	return r.git(nil, "rev-parse", "HEAD")
+}
+
+// abort backs out of a merge or rebase that stopped on conflicts and returns
+// a *ConflictError for them. Other failures leave nothing to back out of.
+func (r *execRepo) abort(err error, command string) error {
+	conflicts, _ := r.git(nil, "diff", "--name-only", "--diff-filter=U")
+	if conflicts == "" {
+		return err
+	}
+
+	if _, abortErr := r.git(nil, command, "--abort"); abortErr != nil {
+		return fmt.Errorf("%v, and could not abort: %v", err, abortErr)
+	}
+
+	return &ConflictError{Paths: strings.Split(conflicts, "\n")}
+}
+
+func (r *execRepo) Squash(message string, author, committer *Signature) (string, error) {
+	base, err := r.git(nil, "merge-base", "master", "origin/master")
+	if err != nil {
+		return "", err
+	}
+
+	head, err := r.git(nil, "rev-parse", "master")
+	if err != nil {
+		return "", err
+	}
+
+	args := append([]string{"commit-tree", "master^{tree}", "-p", base, "-m", message}, r.signFlag()...)
+	commitId, err := r.git(signatureEnv(author, committer), args...)
+	if err != nil {
+		return "", err
+	}
+
+	// Passing the old value makes the update fail if master moved meanwhile
+	msg := fmt.Sprintf("gistbot: squash local commits into %s", commitId)
+	if _, err = r.git(nil, "update-ref", "-m", msg, "refs/heads/master", commitId, head); err != nil {
+		return "", err
+	}
+
+	return commitId, nil
+}
+
+func (r *execRepo) LocalCommits() ([]CommitInfo, error) {
+	out, err := r.git(nil, "log", "--first-parent", "--format=%H%x00%P%x00%an%x00%ae%x00%at%x00%s", "origin/master..master")
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]CommitInfo, 0)
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\x00", 6)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("error reading log output %q", line)
+		}
+
+		when, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error reading log output %q: %v", line, err)
+		}
+
+		commits = append(commits, CommitInfo{
+			Id:      fields[0],
+			Parents: len(strings.Fields(fields[1])),
+			Author:  Signature{Name: fields[2], Email: fields[3], When: time.Unix(when, 0)},
+			Summary: fields[5],
+		})
+	}
+
+	return commits, nil
+}
+
+func (r *execRepo) Untrack(path string) error {
+	_, err := r.git(nil, "rm", "--cached", "--quiet", "--ignore-unmatch", "--", path)
+	return err
+}
+
+func (r *execRepo) Unstage(path string) error {
+	_, err := r.git(nil, "reset", "--quiet", "--", path)
+	return err
+}
+
+func (r *execRepo) RevParse(rev string) (string, error) {
+	return r.git(nil, "rev-parse", "--verify", rev+"^{commit}")
+}
+
+func (r *execRepo) ChangedFiles(from, to string) ([]string, error) {
+	out, err := r.git(nil, "diff", "--name-only", "-z", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	for _, file := range strings.Split(out, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
+
+func (r *execRepo) CommitObject(rev string) ([]byte, error) {
+	return r.run(nil, "cat-file", "commit", rev)
+}
+
+func (r *execRepo) DirtyFiles() ([]string, error) {
+	// Untrimmed, the status columns start with a space
+	out, err := r.run(nil, "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		files = append(files, entry[3:])
+
+		// Renames and copies are followed by the original path
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+
+	return files, nil
+}
+
+func (r *execRepo) FileLog(path string) ([]string, error) {
+	out, err := r.git(nil, "log", "--format=%H", "master", "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out), nil
+}
+
+func (r *execRepo) FileAt(rev, path string) ([]byte, error) {
+	return r.run(nil, "cat-file", "blob", rev+":"+path)
+}
+
+func (r *execRepo) Diff(rev, path string) (string, error) {
+	out, err := r.run(nil, "diff", "--no-color", rev, "--", path)
+	return string(out), err
+}
+
+// git runs a git command in the repository with env added to the
+// environment, and returns its trimmed output.
+func (r *execRepo) git(env []string, args ...string) (string, error) {
+	out, err := r.run(env, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+// run runs a git command and returns its output as is. Commands are killed
+// after GitTimeout so a hung hook or network cannot stall the bot. Errors
+// carry what git printed on stderr.
+func (r *execRepo) run(env []string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.conf.gitTimeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", append(r.signingFlags(), args...)...)
+	cmd.Dir = r.path
+	cmd.Env = append(append(os.Environ(), r.env...), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// ssh and hooks can outlive git and keep its output open
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	slog.Debug("ran git", "repo", r.path, "args", args, "stdout", stdout.String(), "stderr", stderr.String())
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("git %s: timed out after %v", args[0], r.conf.gitTimeout())
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// signingFlags turns on commit signing when Sign is set. Without it the
+// user's own commit.gpgSign setting applies.
+func (r *execRepo) signingFlags() []string {
+	switch r.conf.Sign {
+	case SignGPG:
+		flags := []string{"-c", "commit.gpgSign=true", "-c", "gpg.format=openpgp"}
+		if r.conf.SigningKey != "" {
+			flags = append(flags, "-c", "user.signingKey="+r.conf.SigningKey)
+		}
+		return flags
+	case SignSSH:
+		return []string{"-c", "commit.gpgSign=true", "-c", "gpg.format=ssh", "-c", "user.signingKey=" + r.conf.sshSigningKey()}
+	}
+
+	return nil
+}
+
+// signFlag asks commit-tree for a signature when Sign is set, since unlike
+// commit it ignores commit.gpgSign. The format comes from signingFlags.
+func (r *execRepo) signFlag() []string {
+	switch r.conf.Sign {
+	case SignGPG:
+		if r.conf.SigningKey != "" {
+			return []string{"--gpg-sign=" + r.conf.SigningKey}
+		}
+		return []string{"-S"}
+	case SignSSH:
+		return []string{"--gpg-sign=" + r.conf.sshSigningKey()}
+	}
+
+	return nil
+}
+
+// hasSSHCommand reports whether the user already tells git how to run ssh,
+// in which case PrivateKey is left to their setup.
+func (r *execRepo) hasSSHCommand() bool {
+	if os.Getenv("GIT_SSH_COMMAND") != "" || os.Getenv("GIT_SSH") != "" {
+		return true
+	}
+
+	// git config exits 1 when the key is not set
+	out, err := r.git(nil, "config", "--get", "core.sshCommand")
+	return err == nil && out != ""
+}
+
+// signatureEnv sets the author and committer of the commit git is about to
+// make. Either may be nil to leave it to git.
+func signatureEnv(author, committer *Signature) []string {
+	env := make([]string, 0, 6)
+	if author != nil {
+		env = append(env,
+			"GIT_AUTHOR_NAME="+author.Name,
+			"GIT_AUTHOR_EMAIL="+author.Email,
+			"GIT_AUTHOR_DATE="+author.When.Format(time.RFC3339))
+	}
+	if committer != nil {
+		env = append(env,
+			"GIT_COMMITTER_NAME="+committer.Name,
+			"GIT_COMMITTER_EMAIL="+committer.Email,
+			"GIT_COMMITTER_DATE="+committer.When.Format(time.RFC3339))
+	}
+
+	return env
+}
@@ -0,0 +1,905 @@
+//go:build cgo && !nogit2go
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/libgit2/git2go"
+)
+
+func init() {
+	backends[BackendLibgit2] = libgit2Backend{}
+}
+
+// libgit2Backend works on repositories in process through git2go.
+type libgit2Backend struct{}
+
+func (libgit2Backend) Open(conf *Config, path string) (GitRepo, error) {
+	repo, err := git.OpenRepository(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create repository: %v", err)
+	}
+
+	return &libgit2Repo{conf: conf, repo: repo}, nil
+}
+
+type libgit2Repo struct {
+	conf *Config
+	repo *git.Repository
+}
+
+func (r *libgit2Repo) AddAll(exclude []string) error {
+	index, err := r.repo.Index()
+	if err != nil {
+		return fmt.Errorf("error getting index: %v", err)
+	}
+
+	// A positive return skips the path before it is hashed
+	skip := func(path, matched string) int {
+		if slices.Contains(exclude, path) {
+			return 1
+		}
+		return 0
+	}
+	if err = index.AddAll([]string{}, git.IndexAddDefault, skip); err != nil {
+		return fmt.Errorf("error adding all files to the index")
+	}
+
+	if err = index.Write(); err != nil {
+		return fmt.Errorf("error writing index: %v", err)
+	}
+
+	return nil
+}
+
+func (r *libgit2Repo) Commit(message string, author, committer *Signature) (string, error) {
+	index, err := r.repo.Index()
+	if err != nil {
+		return "", fmt.Errorf("error getting index: %v", err)
+	}
+
+	treeId, err := index.WriteTreeTo(r.repo)
+	if err != nil {
+		return "", fmt.Errorf("error creating tree: %v", err)
+	}
+
+	tree, err := r.repo.LookupTree(treeId)
+	if err != nil {
+		return "", fmt.Errorf("error looking up tree: %v", err)
+	}
+
+	head, err := r.head()
+	if err != nil {
+		return "", err
+	}
+
+	commitTarget, err := r.repo.LookupCommit(head.Target())
+	if err != nil {
+		return "", fmt.Errorf("error looking up commit on local head: %v", err)
+	}
+
+	commitId, err := r.createCommit(author, committer, message, tree, commitTarget)
+	if err != nil {
+		return "", fmt.Errorf("error creating commit: %v", err)
+	}
+
+	if _, err = head.SetTarget(commitId, "commit: "+firstLine(message)); err != nil {
+		return "", fmt.Errorf("error updating HEAD: %v", err)
+	}
+
+	return commitId.String(), nil
+}
+
+func (r *libgit2Repo) Push() error {
+	remote, err := r.origin()
+	if err != nil {
+		return err
+	}
+
+	// A ref the remote refused is reported through the callback, not as an
+	// error from Push
+	refused := ""
+	opts := r.pushOptions()
+	opts.RemoteCallbacks.PushUpdateReferenceCallback = func(refname, status string) git.ErrorCode {
+		if status != "" {
+			refused = fmt.Sprintf("%s: %s", refname, status)
+		}
+		return git.ErrOk
+	}
+
+	if err = remote.Push([]string{"refs/heads/master"}, opts); err != nil {
+		if nonFastForward(err.Error()) {
+			return &RejectedError{Reason: err.Error()}
+		}
+		return fmt.Errorf("error pushing to remote: %v", err)
+	}
+	// Only a push origin is ahead of is worth integrating and retrying, a
+	// hook declining it would decline it again
+	switch {
+	case refused == "":
+	case nonFastForward(refused):
+		return &RejectedError{Reason: refused}
+	default:
+		return fmt.Errorf("remote refused %s", refused)
+	}
+
+	return nil
+}
+
+func (r *libgit2Repo) Fetch() error {
+	remote, err := r.origin()
+	if err != nil {
+		return err
+	}
+
+	if err = remote.Fetch([]string{}, r.fetchOptions(), "gistbot: fetch origin"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *libgit2Repo) Analyze() (Analysis, error) {
+	masterRemote, err := r.masterRemote()
+	if err != nil {
+		return UpToDate, err
+	}
+
+	annotatedCommit, err := r.repo.AnnotatedCommitFromRef(masterRemote)
+	if err != nil {
+		return UpToDate, fmt.Errorf("error looking up origin/master: %v", err)
+	}
+	mergeHeads := []*git.AnnotatedCommit{annotatedCommit}
+	analysis, _, err := r.repo.MergeAnalysis(mergeHeads)
+	if err != nil {
+		return UpToDate, fmt.Errorf("error analysing merge: %v", err)
+	}
+
+	switch {
+	case analysis&git.MergeAnalysisUpToDate != 0:
+		return UpToDate, nil
+	case analysis&git.MergeAnalysisFastForward != 0:
+		return Behind, nil
+	}
+
+	return Diverged, nil
+}
+
+func (r *libgit2Repo) AheadBehind() (int, int, error) {
+	head, err := r.head()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	masterRemote, err := r.masterRemote()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ahead, behind, err := r.repo.AheadBehind(head.Target(), masterRemote.Target())
+	if err != nil {
+		return 0, 0, fmt.Errorf("error counting commits against origin/master: %v", err)
+	}
+
+	return ahead, behind, nil
+}
+
+// FastForward moves master to origin/master and checks out its tree.
+func (r *libgit2Repo) FastForward() (string, error) {
+	head, err := r.head()
+	if err != nil {
+		return "", err
+	}
+	if head.Name() != "refs/heads/master" {
+		return "", fmt.Errorf("HEAD is on %s, not refs/heads/master", head.Name())
+	}
+
+	masterRemote, err := r.masterRemote()
+	if err != nil {
+		return "", err
+	}
+	oid := masterRemote.Target()
+
+	commit, err := r.repo.LookupCommit(oid)
+	if err != nil {
+		return "", fmt.Errorf("error looking up commit %s: %v", oid, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("error looking up tree of %s: %v", oid, err)
+	}
+
+	// Safe checkout refuses to overwrite files that differ from HEAD, a
+	// save landing mid-pull fails the pull rather than being lost
+	if err = r.repo.CheckoutTree(tree, &git.CheckoutOpts{Strategy: git.CheckoutSafe}); err != nil {
+		return "", fmt.Errorf("error checking out %s: %v", oid, err)
+	}
+
+	// HEAD resolves to master, so this moves the branch and logs it in both,
+	// worded the way git pull words it
+	msg := fastForwardAction(oid.String()) + ": Fast-forward"
+	if _, err = head.SetTarget(oid, msg); err != nil {
+		return "", fmt.Errorf("error moving %s to %s: %v", head.Name(), oid, err)
+	}
+
+	return oid.String(), nil
+}
+
+// Merge works the merge out in memory first, so a conflict leaves the
+// repository as it was.
+func (r *libgit2Repo) Merge(message string, author, committer *Signature) (string, error) {
+	head, err := r.head()
+	if err != nil {
+		return "", err
+	}
+
+	masterRemote, err := r.masterRemote()
+	if err != nil {
+		return "", err
+	}
+
+	ours, err := r.repo.LookupCommit(head.Target())
+	if err != nil {
+		return "", fmt.Errorf("error looking up commit on local head: %v", err)
+	}
+	theirs, err := r.repo.LookupCommit(masterRemote.Target())
+	if err != nil {
+		return "", fmt.Errorf("error looking up origin/master: %v", err)
+	}
+
+	index, err := r.repo.MergeCommits(ours, theirs, nil)
+	if err != nil {
+		return "", fmt.Errorf("error merging origin/master: %v", err)
+	}
+	defer index.Free()
+
+	if index.HasConflicts() {
+		return "", conflictError(index)
+	}
+
+	treeId, err := index.WriteTreeTo(r.repo)
+	if err != nil {
+		return "", fmt.Errorf("error creating tree: %v", err)
+	}
+	tree, err := r.repo.LookupTree(treeId)
+	if err != nil {
+		return "", fmt.Errorf("error looking up tree: %v", err)
+	}
+
+	if err = r.repo.CheckoutTree(tree, &git.CheckoutOpts{Strategy: git.CheckoutSafe}); err != nil {
+		return "", fmt.Errorf("error checking out merged tree: %v", err)
+	}
+
+	commitId, err := r.createCommit(author, committer, message, tree, ours, theirs)
+	if err != nil {
+		return "", fmt.Errorf("error creating merge commit: %v", err)
+	}
+
+	msg := fmt.Sprintf("gistbot: merge origin/master %s", theirs.Id())
+	if _, err = head.SetTarget(commitId, msg); err != nil {
+		return "", fmt.Errorf("error updating HEAD: %v", err)
+	}
+
+	return commitId.String(), nil
+}
+
+// Rebase replays the local commits onto origin/master, one in-memory
+// three-way merge per commit, and then moves master to the result. The
+// vendored git2go predates libgit2's rebase bindings, but replaying this way
+// gives the same history. Nothing is written to the branch or the working
+// tree unless every commit replays cleanly, so a conflict aborts cleanly.
+func (r *libgit2Repo) Rebase(committer *Signature) (string, error) {
+	head, err := r.head()
+	if err != nil {
+		return "", err
+	}
+
+	masterRemote, err := r.masterRemote()
+	if err != nil {
+		return "", err
+	}
+
+	base, err := r.repo.MergeBase(head.Target(), masterRemote.Target())
+	if err != nil {
+		return "", fmt.Errorf("error finding merge base: %v", err)
+	}
+
+	commits, err := r.localCommits(head.Target(), base)
+	if err != nil {
+		return "", err
+	}
+
+	onto, err := r.repo.LookupCommit(masterRemote.Target())
+	if err != nil {
+		return "", fmt.Errorf("error looking up origin/master: %v", err)
+	}
+
+	// localCommits lists the newest first, replay the oldest first
+	for i := len(commits) - 1; i >= 0; i-- {
+		if onto, err = r.replay(commits[i], onto, committer); err != nil {
+			return "", err
+		}
+	}
+
+	tree, err := onto.Tree()
+	if err != nil {
+		return "", fmt.Errorf("error looking up rebased tree: %v", err)
+	}
+
+	// Safe checkout only touches files that match the old HEAD
+	if err = r.repo.CheckoutTree(tree, &git.CheckoutOpts{Strategy: git.CheckoutSafe}); err != nil {
+		return "", fmt.Errorf("error checking out rebased tree: %v", err)
+	}
+
+	msg := fmt.Sprintf("gistbot: rebase %d commits onto %s", len(commits), masterRemote.Target())
+	if _, err = head.SetTarget(onto.Id(), msg); err != nil {
+		return "", fmt.Errorf("error updating HEAD: %v", err)
+	}
+
+	return onto.Id().String(), nil
+}
+
+// replay applies the change made by commit on top of onto and returns the
+// new commit.
+func (r *libgit2Repo) replay(commit, onto *git.Commit, committer *Signature) (*git.Commit, error) {
+	if commit.ParentCount() != 1 {
+		return nil, fmt.Errorf("cannot rebase merge commit %s", commit.Id())
+	}
+
+	parentTree, err := commit.Parent(0).Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up tree: %v", err)
+	}
+	ontoTree, err := onto.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up tree: %v", err)
+	}
+	theirTree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up tree: %v", err)
+	}
+
+	index, err := r.repo.MergeTrees(parentTree, ontoTree, theirTree, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error merging %s: %v", commit.Id(), err)
+	}
+	defer index.Free()
+
+	if index.HasConflicts() {
+		return nil, conflictError(index)
+	}
+
+	treeId, err := index.WriteTreeTo(r.repo)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tree: %v", err)
+	}
+	tree, err := r.repo.LookupTree(treeId)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up tree: %v", err)
+	}
+
+	commitId, err := r.createCommit(fromGit(commit.Author()), committer, commit.Message(), tree, onto)
+	if err != nil {
+		return nil, fmt.Errorf("error creating commit: %v", err)
+	}
+
+	return r.repo.LookupCommit(commitId)
+}
+
+// Squash commits the tree of master on top of the merge base with
+// origin/master and moves master to it.
+func (r *libgit2Repo) Squash(message string, author, committer *Signature) (string, error) {
+	head, err := r.head()
+	if err != nil {
+		return "", err
+	}
+
+	masterRemote, err := r.masterRemote()
+	if err != nil {
+		return "", err
+	}
+
+	base, err := r.repo.MergeBase(head.Target(), masterRemote.Target())
+	if err != nil {
+		return "", fmt.Errorf("error finding merge base: %v", err)
+	}
+
+	baseCommit, err := r.repo.LookupCommit(base)
+	if err != nil {
+		return "", fmt.Errorf("error looking up merge base: %v", err)
+	}
+
+	headCommit, err := r.repo.LookupCommit(head.Target())
+	if err != nil {
+		return "", fmt.Errorf("error looking up commit on local head: %v", err)
+	}
+
+	tree, err := headCommit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("error looking up tree: %v", err)
+	}
+
+	commitId, err := r.createCommit(author, committer, message, tree, baseCommit)
+	if err != nil {
+		return "", fmt.Errorf("error creating squashed commit: %v", err)
+	}
+
+	msg := fmt.Sprintf("gistbot: squash local commits into %s", commitId)
+	if _, err = head.SetTarget(commitId, msg); err != nil {
+		return "", fmt.Errorf("error updating HEAD: %v", err)
+	}
+
+	return commitId.String(), nil
+}
+
+// createCommit writes a commit without moving any branch. With Sign set the
+// raw commit is built and signed here, as the vendored git2go cannot sign.
+func (r *libgit2Repo) createCommit(author, committer *Signature, message string, tree *git.Tree, parents ...*git.Commit) (*git.Oid, error) {
+	if r.conf.Sign == "" {
+		return r.repo.CreateCommit("", toGit(author), toGit(committer), message, tree, parents...)
+	}
+
+	parentIds := make([]string, 0, len(parents))
+	for _, parent := range parents {
+		parentIds = append(parentIds, parent.Id().String())
+	}
+
+	signed, err := r.conf.signCommit(buildCommit(tree.Id().String(), parentIds, author, committer, message))
+	if err != nil {
+		return nil, err
+	}
+
+	odb, err := r.repo.Odb()
+	if err != nil {
+		return nil, fmt.Errorf("error opening object database: %v", err)
+	}
+
+	return odb.Write(signed, git.ObjectCommit)
+}
+
+func (r *libgit2Repo) Untrack(path string) error {
+	index, err := r.repo.Index()
+	if err != nil {
+		return fmt.Errorf("error getting index: %v", err)
+	}
+
+	if _, err = index.EntryByPath(path, 0); err != nil {
+		// Not tracked
+		return nil
+	}
+
+	if err = index.RemoveByPath(path); err != nil {
+		return fmt.Errorf("error removing %s from the index: %v", path, err)
+	}
+
+	if err = index.Write(); err != nil {
+		return fmt.Errorf("error writing index: %v", err)
+	}
+
+	return nil
+}
+
+func (r *libgit2Repo) Unstage(path string) error {
+	index, err := r.repo.Index()
+	if err != nil {
+		return fmt.Errorf("error getting index: %v", err)
+	}
+
+	// Before the first commit there is nothing to go back to
+	var entry *git.TreeEntry
+	if commit, err := r.lookupCommit("HEAD"); err == nil {
+		tree, err := commit.Tree()
+		if err != nil {
+			return fmt.Errorf("error looking up tree: %v", err)
+		}
+		entry, _ = tree.EntryByPath(path)
+	}
+
+	if entry == nil {
+		err = index.RemoveByPath(path)
+	} else {
+		err = index.Add(&git.IndexEntry{Mode: entry.Filemode, Id: entry.Id, Path: path})
+	}
+	if err != nil {
+		return fmt.Errorf("error unstaging %s: %v", path, err)
+	}
+
+	if err = index.Write(); err != nil {
+		return fmt.Errorf("error writing index: %v", err)
+	}
+
+	return nil
+}
+
+func (r *libgit2Repo) RevParse(rev string) (string, error) {
+	commit, err := r.lookupCommit(rev)
+	if err != nil {
+		return "", err
+	}
+
+	return commit.Id().String(), nil
+}
+
+func (r *libgit2Repo) ChangedFiles(from, to string) ([]string, error) {
+	trees := make([]*git.Tree, 0, 2)
+	for _, rev := range []string{from, to} {
+		commit, err := r.lookupCommit(rev)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("error looking up tree: %v", err)
+		}
+		trees = append(trees, tree)
+	}
+
+	diff, err := r.repo.DiffTreeToTree(trees[0], trees[1], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error diffing %s and %s: %v", from, to, err)
+	}
+	defer diff.Free()
+
+	deltas, err := diff.NumDeltas()
+	if err != nil {
+		return nil, fmt.Errorf("error reading diff: %v", err)
+	}
+
+	files := make([]string, 0, deltas)
+	for i := 0; i < deltas; i++ {
+		delta, err := diff.GetDelta(i)
+		if err != nil {
+			return nil, fmt.Errorf("error reading diff: %v", err)
+		}
+		files = append(files, delta.NewFile.Path)
+	}
+
+	return files, nil
+}
+
+// lookupCommit resolves rev to the commit it points at.
+func (r *libgit2Repo) lookupCommit(rev string) (*git.Commit, error) {
+	object, err := r.repo.RevparseSingle(rev)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s: %v", rev, err)
+	}
+
+	peeled, err := object.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s: %v", rev, err)
+	}
+
+	return peeled.AsCommit()
+}
+
+func (r *libgit2Repo) CommitObject(rev string) ([]byte, error) {
+	object, err := r.repo.RevparseSingle(rev)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s: %v", rev, err)
+	}
+
+	odb, err := r.repo.Odb()
+	if err != nil {
+		return nil, fmt.Errorf("error opening object database: %v", err)
+	}
+
+	raw, err := odb.Read(object.Id())
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", rev, err)
+	}
+	defer raw.Free()
+
+	return append([]byte(nil), raw.Data()...), nil
+}
+
+func (r *libgit2Repo) LocalCommits() ([]CommitInfo, error) {
+	head, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+
+	masterRemote, err := r.masterRemote()
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := r.repo.MergeBase(head.Target(), masterRemote.Target())
+	if err != nil {
+		return nil, fmt.Errorf("error finding merge base: %v", err)
+	}
+
+	commits, err := r.localCommits(head.Target(), base)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]CommitInfo, 0, len(commits))
+	for _, commit := range commits {
+		author := commit.Author()
+		infos = append(infos, CommitInfo{
+			Id:      commit.Id().String(),
+			Summary: commit.Summary(),
+			Author:  Signature{Name: author.Name, Email: author.Email, When: author.When},
+			Parents: int(commit.ParentCount()),
+		})
+	}
+
+	return infos, nil
+}
+
+// localCommits lists the commits from head back to, but not including, base,
+// following first parents. The newest commit comes first.
+func (r *libgit2Repo) localCommits(head, base *git.Oid) ([]*git.Commit, error) {
+	commits := make([]*git.Commit, 0)
+
+	for oid := head; !oid.Equal(base); {
+		commit, err := r.repo.LookupCommit(oid)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up commit %s: %v", oid, err)
+		}
+		commits = append(commits, commit)
+
+		if commit.ParentCount() == 0 {
+			break
+		}
+		oid = commit.ParentId(0)
+	}
+
+	return commits, nil
+}
+
+// FileLog walks the history from master and keeps the commits whose version
+// of path differs from that of every parent, like git log -- path.
+func (r *libgit2Repo) FileLog(path string) ([]string, error) {
+	walk, err := r.repo.Walk()
+	if err != nil {
+		return nil, fmt.Errorf("error walking history: %v", err)
+	}
+	defer walk.Free()
+
+	walk.Sorting(git.SortTime)
+	if err = walk.PushRef("refs/heads/master"); err != nil {
+		return nil, fmt.Errorf("error walking history: %v", err)
+	}
+
+	ids := make([]string, 0)
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		entry := r.entryId(commit, path)
+
+		// A root commit changed the file if it added it
+		changed := commit.ParentCount() > 0 || !entry.IsZero()
+		for i := uint(0); i < commit.ParentCount(); i++ {
+			if r.entryId(commit.Parent(i), path).Equal(entry) {
+				changed = false
+				break
+			}
+		}
+		if changed {
+			ids = append(ids, commit.Id().String())
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking history: %v", err)
+	}
+
+	return ids, nil
+}
+
+// entryId is the blob id of path in the tree of commit, zero when it is not
+// there.
+func (r *libgit2Repo) entryId(commit *git.Commit, path string) *git.Oid {
+	zero := &git.Oid{}
+	if commit == nil {
+		return zero
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return zero
+	}
+	entry, err := tree.EntryByPath(path)
+	if err != nil {
+		return zero
+	}
+
+	return entry.Id
+}
+
+func (r *libgit2Repo) FileAt(rev, path string) ([]byte, error) {
+	commit, err := r.lookupCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error looking up tree: %v", err)
+	}
+	entry, err := tree.EntryByPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in %s", path, rev)
+	}
+
+	blob, err := r.repo.LookupBlob(entry.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s at %s: %v", path, rev, err)
+	}
+
+	return append([]byte(nil), blob.Contents()...), nil
+}
+
+func (r *libgit2Repo) Diff(rev, path string) (string, error) {
+	commit, err := r.lookupCommit(rev)
+	if err != nil {
+		return "", err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("error looking up tree: %v", err)
+	}
+
+	opts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return "", fmt.Errorf("error diffing %s: %v", path, err)
+	}
+	opts.Pathspec = []string{path}
+	opts.Flags |= git.DiffDisablePathspecMatch
+
+	diff, err := r.repo.DiffTreeToWorkdirWithIndex(tree, &opts)
+	if err != nil {
+		return "", fmt.Errorf("error diffing %s: %v", path, err)
+	}
+	defer diff.Free()
+
+	deltas, err := diff.NumDeltas()
+	if err != nil {
+		return "", fmt.Errorf("error reading diff: %v", err)
+	}
+
+	var out strings.Builder
+	for i := 0; i < deltas; i++ {
+		patch, err := diff.Patch(i)
+		if err != nil {
+			return "", fmt.Errorf("error reading diff: %v", err)
+		}
+		text, err := patch.String()
+		patch.Free()
+		if err != nil {
+			return "", fmt.Errorf("error reading diff: %v", err)
+		}
+		out.WriteString(text)
+	}
+
+	return out.String(), nil
+}
+
+func (r *libgit2Repo) DirtyFiles() ([]string, error) {
+	list, err := r.repo.StatusList(&git.StatusOptions{
+		Show:  git.StatusShowIndexAndWorkdir,
+		Flags: git.StatusOptIncludeUntracked | git.StatusOptRecurseUntrackedDirs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting status: %v", err)
+	}
+	defer list.Free()
+
+	count, err := list.EntryCount()
+	if err != nil {
+		return nil, fmt.Errorf("error getting status: %v", err)
+	}
+
+	files := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		entry, err := list.ByIndex(i)
+		if err != nil {
+			return nil, fmt.Errorf("error getting status: %v", err)
+		}
+
+		if entry.IndexToWorkdir.NewFile.Path != "" {
+			files = append(files, entry.IndexToWorkdir.NewFile.Path)
+		} else {
+			files = append(files, entry.HeadToIndex.NewFile.Path)
+		}
+	}
+
+	return files, nil
+}
+
+func (r *libgit2Repo) credentialsCallback(url string, username string, allowedTypes git.CredType) (git.ErrorCode, *git.Cred) {
+	ret, cred := git.NewCredSshKey("git", r.conf.PublicKey, r.conf.PrivateKey, "")
+	return git.ErrorCode(ret), &cred
+}
+
+func (r *libgit2Repo) certificateCheckCallback(cert *git.Certificate, valid bool, hostname string) git.ErrorCode {
+	return 0
+}
+
+func (r *libgit2Repo) fetchOptions() *git.FetchOptions {
+	fo := git.FetchOptions{
+		RemoteCallbacks: git.RemoteCallbacks{
+			CredentialsCallback:      r.credentialsCallback,
+			CertificateCheckCallback: r.certificateCheckCallback,
+		},
+	}
+	return &fo
+}
+
+func (r *libgit2Repo) pushOptions() *git.PushOptions {
+	po := git.PushOptions{
+		RemoteCallbacks: git.RemoteCallbacks{
+			CredentialsCallback:      r.credentialsCallback,
+			CertificateCheckCallback: r.certificateCheckCallback,
+		},
+	}
+	return &po
+}
+
+func (r *libgit2Repo) origin() (*git.Remote, error) {
+	remote, err := r.repo.Remotes.Lookup("origin")
+	if err != nil {
+		return nil, fmt.Errorf("error looking up origin: %v", err)
+	}
+
+	return remote, nil
+}
+
+func (r *libgit2Repo) masterRemote() (*git.Reference, error) {
+	master, err := r.repo.References.Lookup("refs/remotes/origin/master") // remote master..
+	if err != nil {
+		return nil, fmt.Errorf("error looking up master branch: %v", err)
+	}
+
+	return master, nil
+}
+
+func (r *libgit2Repo) head() (*git.Reference, error) {
+	head, err := r.repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD: %v", err)
+	}
+
+	return head, nil
+}
+
+func toGit(sig *Signature) *git.Signature {
+	return &git.Signature{Name: sig.Name, Email: sig.Email, When: sig.When}
+}
+
+func fromGit(sig *git.Signature) *Signature {
+	return &Signature{Name: sig.Name, Email: sig.Email, When: sig.When}
+}
+
+// conflictError lists the conflicting paths of a merged index.
+func conflictError(index *git.Index) error {
+	conflicts := &ConflictError{Paths: make([]string, 0)}
+
+	iter, err := index.ConflictIterator()
+	if err != nil {
+		return conflicts
+	}
+	defer iter.Free()
+
+	for {
+		conflict, err := iter.Next()
+		if err != nil {
+			break
+		}
+
+		switch {
+		case conflict.Our != nil:
+			conflicts.Paths = append(conflicts.Paths, conflict.Our.Path)
+		case conflict.Their != nil:
+			conflicts.Paths = append(conflicts.Paths, conflict.Their.Path)
+		case conflict.Ancestor != nil:
+			conflicts.Paths = append(conflicts.Paths, conflict.Ancestor.Path)
+		}
+	}
+
+	return conflicts
+}
@@ -0,0 +1,98 @@
+//go:build cgo && !nogit2go
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// localCommit commits a file in the clone at path, as the user would.
+func localCommit(t *testing.T, path, name, content string) string {
+	t.Helper()
+
+	writeFile(t, filepath.Join(path, name), content)
+	runGit(t, path, "add", "--all")
+	runGit(t, path, "commit", "--quiet", "-m", "Change "+name)
+
+	return runGit(t, path, "rev-parse", "HEAD")
+}
+
+func TestLibgit2Rebase(t *testing.T) {
+	remote := newTestRemote(t)
+	repo, path := openGitRepo(t, remote, BackendLibgit2)
+	localCommit(t, path, "todo.txt", "buy milk\n")
+	localCommit(t, path, "notes.txt", "call mum\n")
+	onto := remote.commit("README.md", "# gist\nedited there\n")
+
+	if err := repo.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.Rebase(&Signature{Name: "gistbot", Email: "bot@example.com", When: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if master := runGit(t, path, "rev-parse", "refs/heads/master"); master != id {
+		t.Errorf("master = %s, want the rebased %s", master, id)
+	}
+	if base := runGit(t, path, "rev-parse", "master~2"); base != onto {
+		t.Errorf("master~2 = %s, want the commits replayed onto %s", base, onto)
+	}
+
+	// Authors and messages are kept, the committer is the bot
+	subjects := strings.Split(runGit(t, path, "log", "-2", "--format=%an|%cn|%s", "master"), "\n")
+	want := []string{"Test|gistbot|Change notes.txt", "Test|gistbot|Change todo.txt"}
+	if !slices.Equal(subjects, want) {
+		t.Errorf("rebased log = %q, want %q", subjects, want)
+	}
+
+	for name, content := range map[string]string{"README.md": "# gist\nedited there\n", "todo.txt": "buy milk\n", "notes.txt": "call mum\n"} {
+		if got := readFile(t, filepath.Join(path, name)); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if status := runGit(t, path, "status", "--porcelain"); status != "" {
+		t.Errorf("rebase left changes behind:\n%s", status)
+	}
+}
+
+func TestLibgit2RebaseConflictLeavesRepoUntouched(t *testing.T) {
+	remote := newTestRemote(t)
+	repo, path := openGitRepo(t, remote, BackendLibgit2)
+	// The first commit replays cleanly, the second does not
+	localCommit(t, path, "todo.txt", "buy milk\n")
+	old := localCommit(t, path, "README.md", "# gist\nedited here\n")
+	remote.commit("README.md", "# gist\nedited there\n")
+
+	if err := repo.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := repo.Rebase(&Signature{Name: "gistbot", Email: "bot@example.com", When: time.Now()})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Rebase() error = %v, want a *ConflictError", err)
+	}
+	if !slices.Equal(conflict.Paths, []string{"README.md"}) {
+		t.Errorf("conflicting paths = %v, want [README.md]", conflict.Paths)
+	}
+
+	for _, ref := range []string{"refs/heads/master", "HEAD"} {
+		if id := runGit(t, path, "rev-parse", ref); id != old {
+			t.Errorf("%s moved to %s after a conflict", ref, id)
+		}
+	}
+	if got := readFile(t, filepath.Join(path, "README.md")); got != "# gist\nedited here\n" {
+		t.Errorf("README.md = %q, the local edit was lost", got)
+	}
+	if status := runGit(t, path, "status", "--porcelain"); status != "" {
+		t.Errorf("conflict left changes behind:\n%s", status)
+	}
+	if _, err := gitOutput(path, "rev-parse", "--verify", "--quiet", "REBASE_HEAD"); err == nil {
+		t.Error("conflict left a rebase in progress")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openGitRepo opens a fresh clone of remote through the backend.
+func openGitRepo(t *testing.T, remote *testRemote, backend string) (GitRepo, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	conf := testConfig(t, root, backend)
+	path := remote.clone(filepath.Join(root, "notes"))
+
+	repo, err := conf.backend().Open(conf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo, path
+}
+
+func TestFastForward(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		repo, path := openGitRepo(t, remote, backend)
+		id := remote.commit("todo.txt", "buy milk\n")
+
+		if err := repo.Fetch(); err != nil {
+			t.Fatal(err)
+		}
+		if analysis, err := repo.Analyze(); err != nil || analysis != Behind {
+			t.Fatalf("Analyze() = %v, %v, want Behind", analysis, err)
+		}
+
+		got, err := repo.FastForward()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != id {
+			t.Errorf("FastForward() = %s, want %s", got, id)
+		}
+		if master := runGit(t, path, "rev-parse", "refs/heads/master"); master != id {
+			t.Errorf("master = %s, want %s", master, id)
+		}
+		if content := readFile(t, filepath.Join(path, "todo.txt")); content != "buy milk\n" {
+			t.Errorf("todo.txt = %q, want the tree of %s checked out", content, id)
+		}
+		if status := runGit(t, path, "status", "--porcelain"); status != "" {
+			t.Errorf("checkout left changes behind:\n%s", status)
+		}
+
+		want := "gistbot: fast-forward to " + id + ": Fast-forward"
+		for _, ref := range []string{"refs/heads/master", "HEAD"} {
+			if msg := runGit(t, path, "reflog", "show", "-n", "1", "--format=%gs", ref); msg != want {
+				t.Errorf("%s reflog = %q, want %q", ref, msg, want)
+			}
+		}
+	})
+}
+
+func TestFastForwardKeepsLocalFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "modified file", file: "README.md"},
+		{name: "untracked file", file: "todo.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, backend string) {
+				remote := newTestRemote(t)
+				repo, path := openGitRepo(t, remote, backend)
+				old := runGit(t, path, "rev-parse", "refs/heads/master")
+
+				// A save that landed while the pull was on its way
+				writeFile(t, filepath.Join(path, tt.file), "saved here\n")
+				remote.commit(tt.file, "saved there\n")
+
+				if err := repo.Fetch(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := repo.FastForward(); err == nil {
+					t.Fatal("FastForward() over a local change succeeded, want an error")
+				}
+
+				if master := runGit(t, path, "rev-parse", "refs/heads/master"); master != old {
+					t.Errorf("master moved to %s after a failed fast-forward", master)
+				}
+				if content := readFile(t, filepath.Join(path, tt.file)); content != "saved here\n" {
+					t.Errorf("%s = %q, the local change was lost", tt.file, content)
+				}
+			})
+		})
+	}
+}
+
+func TestFastForwardOnlyMovesMaster(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		repo, path := openGitRepo(t, remote, backend)
+		old := runGit(t, path, "rev-parse", "refs/heads/master")
+		runGit(t, path, "checkout", "--quiet", "-b", "draft")
+		remote.commit("todo.txt", "buy milk\n")
+
+		if err := repo.Fetch(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FastForward(); err == nil {
+			t.Fatal("FastForward() off master succeeded, want an error")
+		}
+
+		for _, ref := range []string{"refs/heads/master", "refs/heads/draft"} {
+			if id := runGit(t, path, "rev-parse", ref); id != old {
+				t.Errorf("%s moved to %s", ref, id)
+			}
+		}
+	})
+}
+
+func TestSquashSigns(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		conf := testConfig(t, root, backend)
+
+		key := filepath.Join(t.TempDir(), "id_ed25519")
+		if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", key).CombinedOutput(); err != nil {
+			t.Skipf("ssh-keygen: %v\n%s", err, out)
+		}
+		conf.Sign = SignSSH
+		conf.SigningKey = key
+
+		repo := openRepository(t, conf, remote.clone(filepath.Join(root, "notes")))
+		commitFile(t, repo, "one.txt", "one\n")
+		commitFile(t, repo, "two.txt", "two\n")
+		if err := repo.Squash(); err != nil {
+			t.Fatal(err)
+		}
+
+		if parent := runGit(t, repo.path, "rev-parse", "master^"); parent != remote.head() {
+			t.Fatalf("master^ = %s, want the commits squashed onto %s", parent, remote.head())
+		}
+
+		signers := filepath.Join(t.TempDir(), "allowed_signers")
+		writeFile(t, signers, conf.Email+" "+readFile(t, key+".pub"))
+		if _, err := gitOutput(repo.path, "-c", "gpg.ssh.allowedSignersFile="+signers, "verify-commit", "master"); err != nil {
+			t.Errorf("squashed commit is not signed: %v\n%s", err, runGit(t, repo.path, "cat-file", "commit", "master"))
+		}
+	})
+}
+
+func TestCommitReflog(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		conf := testConfig(t, root, backend)
+		// The trailer makes the message span several lines
+		conf.HostTrailer = true
+
+		repo := openRepository(t, conf, remote.clone(filepath.Join(root, "notes")))
+		commitFile(t, repo, "todo.txt", "buy milk\n")
+
+		if body := runGit(t, repo.path, "log", "-1", "--format=%b", "master"); !strings.Contains(body, hostTrailer) {
+			t.Fatalf("commit body = %q, want the host trailer", body)
+		}
+		want := "commit: " + commitMessage
+		for _, ref := range []string{"refs/heads/master", "HEAD"} {
+			if msg := runGit(t, repo.path, "reflog", "show", "-n", "1", "--format=%gs", ref); msg != want {
+				t.Errorf("%s reflog = %q, want %q", ref, msg, want)
+			}
+		}
+	})
+}
+
+func TestNonFastForward(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   bool
+	}{
+		{"refs/heads/master: fetch first", true},
+		{"refs/heads/master: non-fast-forward", true},
+		{"cannot push non-fastforwardable reference", true},
+		{"refs/heads/master: pre-receive hook declined", false},
+		{"refs/heads/master: protected branch hook declined", false},
+		{"refs/heads/master: deny updating a hidden ref", false},
+	}
+
+	for _, tt := range tests {
+		if got := nonFastForward(tt.reason); got != tt.want {
+			t.Errorf("nonFastForward(%q) = %t, want %t", tt.reason, got, tt.want)
+		}
+	}
+}
@@ -1,33 +1,72 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 type Bot struct {
-	finder  *Finder
-	watcher *Watcher
-	conf    *Config
-	repos   []string
-	events  chan string
-	errors  chan error
+	finder   *Finder
+	watcher  EventSource
+	clock    Clock
+	notifier *Notifiers
+	conf     *Config
+	repos    []string
+	events   chan string
+	errors   chan error
+
+	// mu guards conf, finder and repos, which are swapped on reload
+	mu            sync.RWMutex
+	configWatcher *fsnotify.Watcher
+
+	statePath    string
+	stateWatcher *fsnotify.Watcher
+	resumed      chan string
+
+	// mirrorMu serializes syncing mirrored files
+	mirrorMu      sync.Mutex
+	mirrorWatcher *fsnotify.Watcher
+	mirrorDirs    []string
+
+	// stopping asks the listener to flush held commits and pushes and
+	// close the channel it is given when done
+	stopping chan chan struct{}
 }
 
 func NewBot(conf *Config) (*Bot, error) {
-	bot := Bot{
-		conf:   conf,
-		events: make(chan string, 3),
-		errors: make(chan error, 2),
-	}
-	bot.finder = NewFinder(conf)
-
 	watcher, err := NewWatcher(conf)
 	if err != nil {
 		return nil, err
 	}
-	bot.watcher = watcher
+
+	return newBot(conf, watcher, realClock{})
+}
+
+// newBot builds a Bot on the given event source and clock, which lets the bot
+// be driven without fsnotify or real time.
+func newBot(conf *Config, watcher EventSource, clock Clock) (*Bot, error) {
+	statePath, err := filepath.Abs(stateFile(conf))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving state file: %v", err)
+	}
+
+	bot := Bot{
+		watcher:   watcher,
+		clock:     clock,
+		conf:      conf,
+		events:    make(chan string, 3),
+		errors:    make(chan error, 2),
+		statePath: statePath,
+		resumed:   make(chan string),
+		stopping:  make(chan chan struct{}),
+	}
+	bot.finder = NewFinder(conf)
+	bot.notifier = NewNotifiers(conf, clock)
 
 	return &bot, nil
 }
@@ -37,27 +76,72 @@ func (b *Bot) Start() error {
 		return err
 	}
 
-	b.pullAll()
+	b.reconcileAll(b.repos)
 
 	for _, err := range b.watcher.AddWatches(b.repos) {
-		log.Println(err)
+		slog.Error("error adding watch", "err", err)
+	}
+
+	configWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating config watcher: %v", err)
 	}
+	b.configWatcher = configWatcher
+
+	stateWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating state watcher: %v", err)
+	}
+	b.stateWatcher = stateWatcher
+
+	mirrorWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating mirror watcher: %v", err)
+	}
+	b.mirrorWatcher = mirrorWatcher
 
 	// Watch and Listen in separate go routines
 	go b.listenForChanges()
 	go b.watcher.Watch(b.events, b.errors)
+	go b.watchConfig()
+	go b.watchState()
+	go b.watchMirrors()
 
 	return nil
 }
 
+// Stop commits and pushes anything held back by the commit and push
+// policies, then stops watching.
 func (b *Bot) Stop() error {
-	if err := b.watcher.Watcher.Close(); err != nil {
+	done := make(chan struct{})
+	b.stopping <- done
+	<-done
+
+	if b.configWatcher != nil {
+		b.configWatcher.Close()
+	}
+	if b.stateWatcher != nil {
+		b.stateWatcher.Close()
+	}
+	if b.mirrorWatcher != nil {
+		b.mirrorWatcher.Close()
+	}
+
+	if err := b.watcher.Close(); err != nil {
 		return fmt.Errorf("error while closing watcher: %v", err)
 	}
 
 	return nil
 }
 
+// config returns the current config, which may be replaced by a reload.
+func (b *Bot) config() *Config {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.conf
+}
+
 // Crappy name, this sets the git paths of the Bot using the finder
 func (b *Bot) paths() error {
 	repos, err := b.finder.Find()
@@ -69,72 +153,325 @@ func (b *Bot) paths() error {
 	return nil
 }
 
-func (b *Bot) pullAll() {
-	ch := make(chan error)
+type reconcileResult struct {
+	path string
+	rec  *Reconciliation
+	err  error
+}
+
+// reconcileAll brings the repositories up to date on start, or when they are
+// first found: changes made while the bot was not watching are committed,
+// origin is pulled and commits origin does not have are pushed.
+func (b *Bot) reconcileAll(repos []string) {
+	ch := make(chan reconcileResult)
 	badPaths := make([]string, 0)
 
-	for _, path := range b.repos {
-		repo, err := NewRepository(b.conf, path)
+	state, err := LoadState(b.statePath)
+	if err != nil {
+		slog.Error("error loading state", "file", b.statePath, "err", err)
+		state = &State{}
+	}
+
+	for _, path := range repos {
+		// A paused repository is being edited, leave its checkout alone
+		if state.IsPaused(path, b.clock.Now()) {
+			slog.Info("paused, not pulling", "repo", path)
+			b.notifier.Notify(NotifyPaused, path, errors.New("auto-commit is paused, not pulling"))
+			badPaths = append(badPaths, path)
+			continue
+		}
+
+		repo, err := b.newRepository(path)
 		if err != nil {
+			slog.Error("error opening repository", "repo", path, "err", err)
 			badPaths = append(badPaths, path)
 			continue
 		}
 
-		// Pull the repository in a go routine
-		go repo.Pull(ch)
+		// Reconcile the repository in a go routine
+		go func(repo *Repository) {
+			rec, err := repo.Reconcile()
+			ch <- reconcileResult{path: repo.path, rec: rec, err: err}
+		}(repo)
 	}
 
-	for i := 0; i < len(b.repos)-len(badPaths); i++ {
-		err := <-ch
-		if err != nil {
-			// Todo: need to add to the bad paths here. how do I know what the path is..?
-			fmt.Println(err)
+	for i := 0; i < len(repos)-len(badPaths); i++ {
+		// Reconcile logs its own failures with the repo attached
+		result := <-ch
+		b.markAttention(result.path, result.err)
+		b.deploy(result.path)
+		b.mirror(result.path)
+		if result.err != nil {
+			b.recordSync(result.path, false, result.err)
+			b.notifyPullFailure(result.path, result.err)
+			continue
 		}
+		b.reconciled(result.path, result.rec)
 	}
 
 	// Todo: remove all bad paths (in a bad state) from *Bot.repos
 }
 
+// reconciled pushes the commits a reconciled repository has over origin and
+// reports what was caught up on.
+func (b *Bot) reconciled(repo string, rec *Reconciliation) {
+	if len(rec.Committed) == 0 && rec.Unpushed == 0 {
+		b.recordSync(repo, false, nil)
+		return
+	}
+
+	pushed := 0
+	if rec.Unpushed > 0 {
+		if err := b.pushRepository(repo); err != nil {
+			b.pushFailed(repo, err)
+			return
+		}
+		pushed = rec.Unpushed
+	}
+	b.recordSync(repo, pushed > 0, nil)
+
+	slog.Info("reconciled changes made while not running", "repo", repo, "committed", rec.Committed, "pushed", pushed)
+}
+
+// markAttention flags a repository whose changes conflict with the remote,
+// and clears the flag once it syncs cleanly again.
+func (b *Bot) markAttention(repo string, err error) {
+	reason := ""
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		reason = conflict.Error()
+		slog.Warn("repository needs attention", "repo", repo, "reason", reason)
+	} else if err != nil {
+		// Other failures, like a network error, say nothing about the repo
+		return
+	}
+
+	// Read first so a clean pull does not rewrite the file
+	if state, err := LoadState(b.statePath); err == nil {
+		current := ""
+		if rs, ok := state.Repos[repo]; ok {
+			current = rs.NeedsAttention
+		}
+		if current == reason {
+			return
+		}
+	}
+
+	_, err = UpdateState(b.statePath, func(s *State) error {
+		s.Repo(repo).NeedsAttention = reason
+		return nil
+	})
+	if err != nil {
+		slog.Error("error saving state", "repo", repo, "err", err)
+	}
+}
+
+// recordSync remembers how the last sync of repo went for the status
+// command: when it was pushed, and what went wrong if anything did. A nil err
+// clears the last error.
+func (b *Bot) recordSync(repo string, pushed bool, syncErr error) {
+	_, err := UpdateState(b.statePath, func(s *State) error {
+		rs := s.Repo(repo)
+		if pushed {
+			rs.LastPush = b.clock.Now()
+		}
+		rs.LastError = ""
+		if syncErr != nil {
+			rs.LastError = syncErr.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("error saving state", "repo", repo, "err", err)
+	}
+}
+
+// notifyPullFailure reports a pull that failed, telling conflicts apart.
+func (b *Bot) notifyPullFailure(repo string, err error) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		b.notifier.Notify(NotifyConflict, repo, err)
+		return
+	}
+
+	b.notifier.Notify(NotifyPullFailed, repo, err)
+}
+
 func (b *Bot) listenForChanges() {
-	log.Println("listening for changes...")
+	slog.Info("listening for changes")
+	sched := newScheduler(b.clock.Now())
+
 	for {
+		conf := b.config()
+		commitC, stopCommit := after(b.clock, sched.nextCommit(conf))
+		pushC, stopPush := after(b.clock, sched.nextPush(conf, b.clock.Now()))
+
 		select {
 
 		case changedFile := <-b.events:
-			if err := b.updateRepository(changedFile); err != nil {
-				log.Printf("error updating the repository %s: %v", changedFile, err)
+			repo := filepath.Dir(changedFile)
+			if !b.hold(repo, changedFile) {
+				b.changed(sched, repo, changedFile)
 			}
-			log.Printf("repository updated")
+
+		case repo := <-b.resumed:
+			b.changed(sched, repo, "")
+
+		case <-commitC:
+			b.commitHeld(sched)
+
+		case <-pushC:
+			b.pushHeld(sched)
+
+		case done := <-b.stopping:
+			b.commitHeld(sched)
+			b.pushHeld(sched)
+			close(done)
+			return
 
 		case err := <-b.errors:
-			log.Printf("error from the watcher: %v", err)
+			slog.Error("error from the watcher", "err", err)
+		}
+
+		stopCommit()
+		stopPush()
+	}
+}
+
+// changed commits a change to repo now or holds it for the next scheduled
+// commit, depending on CommitPolicy.
+func (b *Bot) changed(sched *scheduler, repo, file string) {
+	if b.config().commitPolicy() == PolicyInterval {
+		slog.Debug("change held for the next commit", "repo", repo, "file", file)
+		sched.uncommitted[repo] = true
+		return
+	}
+
+	b.commit(sched, repo)
+}
+
+// commit commits repo and pushes it now or holds it for the next scheduled
+// push, depending on PushPolicy.
+func (b *Bot) commit(sched *scheduler, repo string) {
+	if err := b.commitRepository(repo); err != nil {
+		slog.Error("error committing repository", "repo", repo, "err", err)
+		b.recordSync(repo, false, err)
+		var hookErr *HookError
+		if errors.As(err, &hookErr) {
+			b.notifier.Notify(NotifyCommitBlocked, repo, err)
+		}
+		return
+	}
+	// Refresh copies of the saved files
+	b.deploy(repo)
+	b.mirror(repo)
+
+	if b.config().pushPolicy() != PolicySave {
+		slog.Debug("commit held for the next push", "repo", repo)
+		sched.unpushed[repo] = true
+		return
+	}
+
+	if err := b.pushRepository(repo); err != nil {
+		b.pushFailed(repo, err)
+		sched.unpushed[repo] = true
+		return
+	}
+	b.recordSync(repo, true, nil)
+	slog.Info("repository updated", "repo", repo)
+}
+
+func (b *Bot) commitHeld(sched *scheduler) {
+	for repo := range sched.uncommitted {
+		delete(sched.uncommitted, repo)
+		b.commit(sched, repo)
+	}
+	sched.lastCommit = b.clock.Now()
+}
+
+// pushHeld pushes every repository with unpushed commits. Failed pushes are
+// kept and tried again next time.
+func (b *Bot) pushHeld(sched *scheduler) {
+	for repo := range sched.unpushed {
+		if err := b.pushRepository(repo); err != nil {
+			b.pushFailed(repo, err)
+			continue
 		}
+		delete(sched.unpushed, repo)
+		b.recordSync(repo, true, nil)
+		slog.Info("repository pushed", "repo", repo)
 	}
+	sched.lastPush = b.clock.Now()
+}
+
+// pushFailed reports a failed push. A push can fail on a conflict when it
+// was rejected and the remote changes could not be integrated.
+func (b *Bot) pushFailed(repo string, err error) {
+	slog.Error("error pushing repository", "repo", repo, "err", err)
+	b.recordSync(repo, false, err)
+
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		b.markAttention(repo, err)
+		b.notifier.Notify(NotifyConflict, repo, err)
+		return
+	}
+
+	b.notifier.Notify(NotifyPushFailed, repo, err)
 }
 
-func (b *Bot) updateRepository(changedFile string) error {
-	dirPath, err := filepath.Abs(filepath.Dir(changedFile))
+func (b *Bot) commitRepository(repoPath string) error {
+	dirPath, err := filepath.Abs(repoPath)
 	if err != nil {
 		return err
 	}
 
-	repo, err := NewRepository(b.conf, dirPath)
+	repo, err := b.newRepository(dirPath)
 	if err != nil {
 		return err
 	}
 
-	tree, err := repo.Add()
-	if err != nil {
+	if err = repo.Add(); err != nil {
 		return fmt.Errorf("error git add: %v", err)
 	}
 
-	if err = repo.Commit(tree); err != nil {
+	return repo.Commit()
+}
+
+func (b *Bot) pushRepository(repoPath string) error {
+	repo, err := b.newRepository(repoPath)
+	if err != nil {
 		return err
 	}
 
-	if err = repo.Push(); err != nil {
-		return fmt.Errorf("error git push: %v", err)
+	if b.config().Squash {
+		if err = repo.Squash(); err != nil {
+			// Pushing the commits as they are beats not pushing at all
+			slog.Warn("error squashing, pushing unsquashed", "repo", repoPath, "err", err)
+		}
+	}
+
+	pulled, err := repo.Push()
+	if pulled {
+		// A retry pulled in changes after this cycle deployed
+		b.deploy(repoPath)
+		b.mirror(repoPath)
+	}
+	if err != nil {
+		return fmt.Errorf("error git push: %w", err)
 	}
 
 	return nil
 }
+
+// newRepository opens the repository at path with its notifications sent
+// through the bot.
+func (b *Bot) newRepository(path string) (*Repository, error) {
+	repo, err := NewRepository(b.config(), path)
+	if err != nil {
+		return nil, err
+	}
+	repo.notify = func(kind string, err error) { b.notifier.Notify(kind, path, err) }
+
+	return repo, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startBot runs a bot over conf on a fake watcher and clock. The bot is
+// stopped when the test ends, or earlier through the returned func.
+func startBot(t *testing.T, conf *Config, clock Clock) (*fakeWatcher, func()) {
+	t.Helper()
+
+	watcher := newFakeWatcher()
+	bot, err := newBot(conf, watcher, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bot.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := sync.OnceFunc(func() {
+		if err := bot.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Cleanup(stop)
+
+	return watcher, stop
+}
+
+func TestBotPushesSavedFile(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		repo := remote.clone(filepath.Join(root, "notes"))
+
+		watcher, _ := startBot(t, testConfig(t, root, backend), newFakeClock())
+		if !watcher.isWatched(repo) {
+			t.Fatalf("%s is not watched", repo)
+		}
+
+		watcher.save(t, filepath.Join(repo, "todo.txt"), "buy milk\n")
+		eventually(t, "the save to reach the remote", func() bool {
+			content, ok := remote.file("todo.txt")
+			return ok && content == "buy milk"
+		})
+
+		if subject := runGit(t, remote.path, "log", "-1", "--format=%s", "master"); subject != commitMessage {
+			t.Errorf("remote head subject = %q, want %q", subject, commitMessage)
+		}
+		if author := runGit(t, remote.path, "log", "-1", "--format=%an <%ae>", "master"); author != "Gist Bot <bot@example.com>" {
+			t.Errorf("remote head author = %q", author)
+		}
+	})
+}
+
+func TestBotPullsOnRestart(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		repo := remote.clone(filepath.Join(root, "notes"))
+		conf := testConfig(t, root, backend)
+
+		_, stop := startBot(t, conf, newFakeClock())
+		stop()
+
+		head := remote.commit("todo.txt", "from another machine\n")
+
+		startBot(t, conf, newFakeClock())
+		if got := runGit(t, repo, "rev-parse", "master"); got != head {
+			t.Errorf("local master = %s, want the remote head %s", got, head)
+		}
+		if got := readFile(t, filepath.Join(repo, "todo.txt")); got != "from another machine\n" {
+			t.Errorf("todo.txt = %q", got)
+		}
+	})
+}
+
+func TestBotPushesOnInterval(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		repo := remote.clone(filepath.Join(root, "notes"))
+		conf := testConfig(t, root, backend)
+		conf.PushPolicy = PolicyInterval
+		conf.PushInterval = "10m"
+
+		clock := newFakeClock()
+		watcher, _ := startBot(t, conf, clock)
+
+		watcher.save(t, filepath.Join(repo, "todo.txt"), "buy milk\n")
+		eventually(t, "the save to be committed", func() bool {
+			out, err := gitOutput(repo, "log", "-1", "--format=%s", "master")
+			return err == nil && out == commitMessage
+		})
+		if _, ok := remote.file("todo.txt"); ok {
+			t.Fatal("the commit was pushed before the push interval")
+		}
+
+		eventually(t, "the interval push", func() bool {
+			clock.Advance(time.Minute)
+			_, ok := remote.file("todo.txt")
+			return ok
+		})
+	})
+}
+
+func TestBotRedeploysAfterRejectedPush(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		remote.commit("todo.txt", "buy milk\n")
+		root := t.TempDir()
+		repo := remote.clone(filepath.Join(root, "notes"))
+
+		target := filepath.Join(t.TempDir(), "todo.txt")
+		conf := testConfig(t, root, backend)
+		conf.Repos = map[string]*RepoConfig{repo: {LinkMode: LinkCopy, Links: map[string]string{"todo.txt": target}}}
+
+		watcher, _ := startBot(t, conf, newFakeClock())
+		if got := readFile(t, target); got != "buy milk\n" {
+			t.Fatalf("deployed todo.txt = %q", got)
+		}
+
+		// Another machine pushes first, so the next push is rejected
+		remote.commit("todo.txt", "buy bread\n")
+		watcher.save(t, filepath.Join(repo, "notes.txt"), "call mum\n")
+
+		eventually(t, "the pulled change to be deployed", func() bool {
+			data, err := os.ReadFile(target)
+			return err == nil && string(data) == "buy bread\n"
+		})
+		if _, ok := remote.file("notes.txt"); !ok {
+			t.Error("the save was not pushed after integrating")
+		}
+	})
+}
+
+// webhookNotifications collects the notifications posted to the webhook of
+// conf.
+func webhookNotifications(t *testing.T, conf *Config) <-chan Notification {
+	t.Helper()
+
+	received := make(chan Notification, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("error decoding notification: %v", err)
+		}
+		received <- n
+	}))
+	t.Cleanup(srv.Close)
+	conf.Notify.Webhook = srv.URL
+
+	return received
+}
+
+// waitNotification waits for a notification of kind, skipping any other.
+func waitNotification(t *testing.T, received <-chan Notification, kind string) Notification {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case n := <-received:
+			if n.Kind == kind {
+				return n
+			}
+		case <-timeout:
+			t.Fatalf("no %s notification was sent", kind)
+		}
+	}
+}
+
+func TestBotNotifiesPausedRepo(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		repo := remote.clone(filepath.Join(root, "notes"))
+		conf := testConfig(t, root, backend)
+		received := webhookNotifications(t, conf)
+
+		watcher, _ := startBot(t, conf, newFakeClock())
+		_, err := UpdateState(conf.StateFile, func(s *State) error {
+			s.Repo(repo).Paused = true
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		watcher.save(t, filepath.Join(repo, "todo.txt"), "buy milk\n")
+		if n := waitNotification(t, received, NotifyPaused); n.Repo != repo {
+			t.Errorf("paused notification for %s, want %s", n.Repo, repo)
+		}
+		if _, ok := remote.file("todo.txt"); ok {
+			t.Error("the save was pushed while paused")
+		}
+	})
+}
+
+func TestBotNotifiesHeldFiles(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		repo := remote.clone(filepath.Join(root, "notes"))
+		conf := testConfig(t, root, backend)
+		received := webhookNotifications(t, conf)
+
+		watcher, _ := startBot(t, conf, newFakeClock())
+		watcher.save(t, filepath.Join(repo, "photo.png"), "\x89PNG\r\n\x1a\n\x00\x00")
+
+		n := waitNotification(t, received, NotifyFilesHeld)
+		if n.Repo != repo || !strings.Contains(n.Message, "photo.png (binary)") {
+			t.Errorf("delivered %+v, want photo.png held as binary in %s", n, repo)
+		}
+	})
+}
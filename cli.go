@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// commands are run from the command line as `gistbot <command> [args]`.
+// "run" starts the bot and is the default.
+var commands = map[string]func(conf *Config, args []string) error{
+	"run":     runBot,
+	"pause":   pauseCommand,
+	"resume":  resumeCommand,
+	"status":  statusCommand,
+	"log":     logCommand,
+	"diff":    diffCommand,
+	"restore": restoreCommand,
+}
+
+// pauseCommand stops auto-commit for one repository, or for all of them
+// when no repository is given, until resumed or for a limited time.
+func pauseCommand(conf *Config, args []string) error {
+	flags := flag.NewFlagSet("pause", flag.ExitOnError)
+	duration := flags.Duration("for", 0, "resume automatically after this long, e.g. 1h")
+	until := flags.String("until", "", "resume automatically at this time, HH:MM or RFC 3339")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s pause [-for duration | -until time] [repo]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	repo, err := resolveRepo(conf, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	pause := PauseState{Paused: true}
+	switch {
+	case *duration > 0:
+		pause.ResumeAt = time.Now().Add(*duration)
+	case *until != "":
+		if pause.ResumeAt, err = parseUntil(*until, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	_, err = UpdateState(stateFile(conf), func(s *State) error {
+		if repo == "" {
+			s.Global = pause
+		} else {
+			s.Repo(repo).PauseState = pause
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("paused %s%s\n", repoLabel(repo), resumeLabel(pause))
+	return nil
+}
+
+// resumeCommand ends a pause. Changes held back while paused are committed
+// together by the running bot.
+func resumeCommand(conf *Config, args []string) error {
+	repo, err := resolveRepo(conf, firstArg(args))
+	if err != nil {
+		return err
+	}
+
+	_, err = UpdateState(stateFile(conf), func(s *State) error {
+		if repo == "" {
+			s.Global = PauseState{}
+		} else {
+			s.Repo(repo).PauseState = PauseState{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("resumed %s\n", repoLabel(repo))
+	return nil
+}
+
+// resolveRepo finds the repository named on the command line, by path or by
+// its directory name under one of the roots. An empty name means all
+// repositories and resolves to "".
+func resolveRepo(conf *Config, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	repos, err := NewFinder(conf).Find()
+	if err != nil {
+		return "", fmt.Errorf("error finding repos %v", err)
+	}
+
+	if abs, err := filepath.Abs(name); err == nil {
+		for _, repo := range repos {
+			if repo == abs {
+				return repo, nil
+			}
+		}
+	}
+
+	matches := make([]string, 0)
+	for _, repo := range repos {
+		if filepath.Base(repo) == name {
+			matches = append(matches, repo)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no repository named %s", name)
+	case 1:
+		return matches[0], nil
+	}
+
+	return "", fmt.Errorf("%s is ambiguous, give the full path: %v", name, matches)
+}
+
+// parseUntil reads a time of day, taken as the next time that clock time
+// comes around, or a full RFC 3339 timestamp.
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("15:04", value, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected HH:MM or RFC 3339", value)
+	}
+
+	at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+
+	return at, nil
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	return args[0]
+}
+
+func repoLabel(repo string) string {
+	if repo == "" {
+		return "all repositories"
+	}
+
+	return repo
+}
+
+func resumeLabel(pause PauseState) string {
+	if pause.ResumeAt.IsZero() {
+		return ""
+	}
+
+	return " until " + pause.ResumeAt.Format("2006-01-02 15:04")
+}
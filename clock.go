@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// Clock is the bot's source of time. Schedules and pauses read the time and
+// wait through it, so a fake clock can drive them deterministically.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed,
+	// along with a func that stops it early.
+	After(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildCommit writes a raw commit object the way git does, ready to be
+// signed and stored.
+func buildCommit(tree string, parents []string, author, committer *Signature, message string) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	for _, parent := range parents {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author %s\n", formatSignature(author))
+	fmt.Fprintf(&buf, "committer %s\n", formatSignature(committer))
+	buf.WriteString("\n")
+	buf.WriteString(message)
+	if !strings.HasSuffix(message, "\n") {
+		buf.WriteString("\n")
+	}
+
+	return buf.Bytes()
+}
+
+// formatSignature formats an author or committer line, name <email> seconds
+// and zone offset.
+func formatSignature(sig *Signature) string {
+	return fmt.Sprintf("%s <%s> %d %s", sig.Name, sig.Email, sig.When.Unix(), sig.When.Format("-0700"))
+}
+
+// commitTime reads the committer time of a raw commit object.
+func commitTime(raw []byte) (time.Time, error) {
+	committer, err := parseSignature(commitHeader(raw, "committer"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return committer.When, nil
+}
+
+// commitHeader returns the value of the named header of a raw commit object,
+// empty when it has none.
+func commitHeader(raw []byte, name string) string {
+	headers, _, _ := bytes.Cut(raw, []byte("\n\n"))
+	for _, line := range strings.Split(string(headers), "\n") {
+		if value, ok := strings.CutPrefix(line, name+" "); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// parseSignature reads an author or committer line as formatSignature
+// writes it.
+func parseSignature(value string) (*Signature, error) {
+	person, when, ok := strings.Cut(value, "> ")
+	name, email, ok2 := strings.Cut(person, " <")
+	fields := strings.Fields(when)
+	if !ok || !ok2 || len(fields) != 2 {
+		return nil, fmt.Errorf("malformed signature %q", value)
+	}
+
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature %q", value)
+	}
+	zone, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature %q", value)
+	}
+
+	return &Signature{Name: name, Email: email, When: time.Unix(seconds, 0).In(zone.Location())}, nil
+}
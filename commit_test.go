@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommitHeaders(t *testing.T) {
+	zone := time.FixedZone("", -7*60*60)
+	author := &Signature{Name: "Gist Bot", Email: "bot@example.com", When: time.Date(2026, 1, 2, 3, 4, 5, 0, zone)}
+	committer := &Signature{Name: "gistbot", Email: "bot@laptop", When: author.When.Add(time.Hour)}
+	raw := buildCommit("4b825dc642cb6eb9a060e54bf8d69288fbee4904", []string{"a", "b"}, author, committer, "Summary\n\nBody\n")
+
+	if got := commitHeader(raw, "tree"); got != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("tree = %q", got)
+	}
+	if got := commitHeader(raw, "parent"); got != "a" {
+		t.Errorf("parent = %q, want the first parent", got)
+	}
+	if got := commitHeader(raw, "gpgsig"); got != "" {
+		t.Errorf("gpgsig = %q, want none", got)
+	}
+
+	parsed, err := parseSignature(commitHeader(raw, "author"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != author.Name || parsed.Email != author.Email || !parsed.When.Equal(author.When) {
+		t.Errorf("author = %+v, want %+v", parsed, author)
+	}
+	if _, offset := parsed.When.Zone(); offset != -7*60*60 {
+		t.Errorf("author zone offset = %d, want -7h", offset)
+	}
+
+	when, err := commitTime(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !when.Equal(committer.When) {
+		t.Errorf("commitTime() = %v, want %v", when, committer.When)
+	}
+}
+
+func TestParseSignatureMalformed(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"Gist Bot bot@example.com 1767322800 +0000",
+		"Gist Bot <bot@example.com> yesterday +0000",
+		"Gist Bot <bot@example.com> 1767322800",
+		"Gist Bot <bot@example.com> 1767322800 UTC",
+	} {
+		if _, err := parseSignature(value); err == nil {
+			t.Errorf("parseSignature(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -1,42 +1,428 @@
-
 package main
 
 import (
-    "os"
-    "fmt"
-    "encoding/json"
-    "log"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
 )
 
+// envPrefix is prepended to the upper snake case field name to build the
+// environment variable that overrides it, e.g. GISTBOT_ROOT_DIR.
+const envPrefix = "GISTBOT_"
+
+// configNames are the file names looked up in each XDG config directory when
+// no config file is given on the command line.
+var configNames = []string{"config.yaml", "config.yml", "config.toml", "config.json"}
+
 type Config struct {
-	RootDir    string
-	PublicKey  string
-	PrivateKey string
-	Name       string
-	Email      string
+	RootDir    string   `yaml:"RootDir"`
+	RootDirs   []string `yaml:"RootDirs"`
+	PublicKey  string   `yaml:"PublicKey"`
+	PrivateKey string   `yaml:"PrivateKey"`
+	Name       string   `yaml:"Name"`
+	Email      string   `yaml:"Email"`
+	LogLevel   string   `yaml:"LogLevel"`
+	LogFormat  string   `yaml:"LogFormat"`
+	Ignore     []string `yaml:"Ignore"`
+	StateFile  string   `yaml:"StateFile"`
+
+	CommitterName  string `yaml:"CommitterName"`
+	CommitterEmail string `yaml:"CommitterEmail"`
+	HostTrailer    bool   `yaml:"HostTrailer"`
+	VersionTrailer bool   `yaml:"VersionTrailer"`
+
+	CommitPolicy   string   `yaml:"CommitPolicy"`
+	CommitInterval string   `yaml:"CommitInterval"`
+	PushPolicy     string   `yaml:"PushPolicy"`
+	PushInterval   string   `yaml:"PushInterval"`
+	PushAt         []string `yaml:"PushAt"`
+	Squash         bool     `yaml:"Squash"`
+	PullStrategy   string   `yaml:"PullStrategy"`
+	Backend        string   `yaml:"Backend"`
+	GitTimeout     string   `yaml:"GitTimeout"`
+	Sign           string   `yaml:"Sign"`
+	SigningKey     string   `yaml:"SigningKey"`
+
+	Hooks  Hooks                  `yaml:"Hooks"`
+	Repos  map[string]*RepoConfig `yaml:"Repos"`
+	Notify NotifyConfig           `yaml:"Notify"`
+	Vars   map[string]string      `yaml:"Vars"`
+
+	Encrypt           []string `yaml:"Encrypt"`
+	AgeRecipients     []string `yaml:"AgeRecipients"`
+	AgeIdentity       string   `yaml:"AgeIdentity"`
+	Passphrase        string   `yaml:"Passphrase"`
+	PassphraseCommand string   `yaml:"PassphraseCommand"`
+
+	MaxFileSize   string `yaml:"MaxFileSize"`
+	MaxCommitSize string `yaml:"MaxCommitSize"`
+	AllowBinary   bool   `yaml:"AllowBinary"`
+
+	// path is the file the config was loaded from
+	path string
+	// data is the raw file, kept to attach line numbers to validation errors
+	data []byte
 }
 
+// ConfigError describes a problem in a config file. Line is zero when the
+// problem cannot be tied to a line, e.g. a value set from the environment.
+type ConfigError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+// NewConfig loads the config file at path. An empty path searches the XDG
+// config directories. Environment overrides and path expansion are applied
+// before the result is validated.
 func NewConfig(path string) (*Config, error) {
-    log.Printf("Opening config file: %s", path)
-    fp, err := os.Open(path)
-    if err != nil {
-        return nil, fmt.Errorf("error opening config file %v", err)
-    }
-    defer fp.Close()
+	if path == "" {
+		found, err := findConfig()
+		if err != nil {
+			return nil, err
+		}
+		path = found
+	}
+
+	slog.Info("opening config file", "file", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	config := Config{path: path, data: data}
+	if err = config.decode(); err != nil {
+		return nil, err
+	}
+
+	if err = config.applyEnv(); err != nil {
+		return nil, err
+	}
+	config.expandPaths()
+
+	if err = config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Path returns the file the config was loaded from.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Roots returns RootDir followed by any extra RootDirs.
+func (c *Config) Roots() []string {
+	return append([]string{c.RootDir}, c.RootDirs...)
+}
+
+// diffConfig returns the names of the fields that differ between prev and next.
+func diffConfig(prev, next *Config) []string {
+	changed := make([]string, 0)
+
+	ov, nv := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		field := ov.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, field.Name)
+		}
+	}
+
+	return changed
+}
+
+// findConfig returns the first config file found in $XDG_CONFIG_HOME/gistbot
+// or $XDG_CONFIG_DIRS/gistbot.
+func findConfig() (string, error) {
+	dirs := make([]string, 0)
+
+	home := os.Getenv("XDG_CONFIG_HOME")
+	if home == "" {
+		home = expandPath("~/.config")
+	}
+	dirs = append(dirs, home)
+
+	system := os.Getenv("XDG_CONFIG_DIRS")
+	if system == "" {
+		system = "/etc/xdg"
+	}
+	dirs = append(dirs, filepath.SplitList(system)...)
+
+	for _, dir := range dirs {
+		for _, name := range configNames {
+			path := filepath.Join(dir, "gistbot", name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no config file found in %s", strings.Join(dirs, ", "))
+}
+
+func (c *Config) decode() error {
+	switch ext := strings.ToLower(filepath.Ext(c.path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(c.data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return c.jsonError(err)
+		}
+
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(c.data, c); err != nil {
+			return c.errorf(lineInMessage(err.Error()), "%v", err)
+		}
+
+	case ".toml":
+		md, err := toml.Decode(string(c.data), c)
+		if err != nil {
+			return c.errorf(lineInMessage(err.Error()), "%v", err)
+		}
+		if keys := md.Undecoded(); len(keys) > 0 {
+			return c.errorf(c.lineOf(keys[0].String()), "unknown field %q", keys[0].String())
+		}
 
-    var buf []byte = make([]byte, 4096)
-    n, err := fp.Read(buf)
-    buf = buf[:n]
-    if err != nil {
-        return nil, fmt.Errorf("error reading config file: %v", err)
-    }
+	default:
+		return &ConfigError{File: c.path, Msg: fmt.Sprintf("unsupported config format %q", ext)}
+	}
 
-    var config Config
-    err = json.Unmarshal(buf, &config)
-    if err != nil {
-        return nil, fmt.Errorf("invalid json: %v", err)
-    }
+	return nil
+}
+
+func (c *Config) jsonError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return c.errorf(c.lineAt(syntaxErr.Offset), "invalid json: %v", err)
+	case errors.As(err, &typeErr):
+		return c.errorf(c.lineAt(typeErr.Offset), "invalid value for %s: expected %s", typeErr.Field, typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return c.errorf(c.lineOf(field), "unknown field %q", field)
+	}
+
+	return c.errorf(0, "invalid json: %v", err)
+}
+
+// applyEnv overrides fields from GISTBOT_* environment variables. Lists are
+// given comma separated.
+func (c *Config) applyEnv() error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := envPrefix + envName(field.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", name, err)
+			}
+			fv.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", name, err)
+			}
+			fv.SetInt(n)
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() == reflect.String {
+				fv.Set(reflect.ValueOf(strings.Split(value, ",")))
+			}
+		}
+	}
+
+	return nil
+}
+
+// envName turns a field name like RootDir into ROOT_DIR.
+func envName(field string) string {
+	var b strings.Builder
+	for i, r := range field {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+func (c *Config) expandPaths() {
+	for _, p := range []*string{&c.RootDir, &c.PublicKey, &c.PrivateKey, &c.StateFile, &c.SigningKey, &c.AgeIdentity} {
+		*p = expandPath(*p)
+	}
+	for i := range c.RootDirs {
+		c.RootDirs[i] = expandPath(c.RootDirs[i])
+	}
+
+	repos := make(map[string]*RepoConfig, len(c.Repos))
+	for key, repo := range c.Repos {
+		repos[expandPath(key)] = repo
+	}
+	c.Repos = repos
+}
+
+// expandPath expands environment variables and a leading ~ in path.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+
+	return path
+}
+
+func (c *Config) validate() error {
+	errs := make([]error, 0)
+
+	if c.RootDir == "" {
+		errs = append(errs, c.errorf(0, "RootDir is required"))
+	} else if info, err := os.Stat(c.RootDir); err != nil || !info.IsDir() {
+		errs = append(errs, c.errorf(c.lineOf("RootDir"), "RootDir %s is not a directory", c.RootDir))
+	}
+	for _, dir := range c.RootDirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			errs = append(errs, c.errorf(c.lineOf("RootDirs"), "RootDirs: %s is not a directory", dir))
+		}
+	}
+
+	for _, pattern := range c.Ignore {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("Ignore"), "Ignore: bad pattern %q", pattern))
+		}
+	}
+
+	for key, path := range map[string]string{"PublicKey": c.PublicKey, "PrivateKey": c.PrivateKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, c.errorf(c.lineOf(key), "%s: %v", key, err))
+		}
+	}
 
-    return &config, nil
+	if c.Name == "" {
+		errs = append(errs, c.errorf(0, "Name is required"))
+	}
+	if c.Email == "" {
+		errs = append(errs, c.errorf(0, "Email is required"))
+	}
+
+	errs = append(errs, c.validatePolicies()...)
+	errs = append(errs, c.validateSigning()...)
+	errs = append(errs, c.validateHooks()...)
+	errs = append(errs, c.validateLinks()...)
+	errs = append(errs, c.validateMirrors()...)
+	errs = append(errs, c.validateEncryption()...)
+	errs = append(errs, c.validateNotify()...)
+	errs = append(errs, c.validateGuardrails()...)
+
+	switch c.pullStrategy() {
+	case StrategyMerge, StrategyRebase:
+	default:
+		errs = append(errs, c.errorf(c.lineOf("PullStrategy"), "unknown PullStrategy %q", c.PullStrategy))
+	}
+	switch {
+	case c.backend() != nil:
+	case c.Backend == BackendLibgit2:
+		errs = append(errs, c.errorf(c.lineOf("Backend"), "Backend %q is not built in, build with cgo and without the nogit2go tag", c.Backend))
+	default:
+		errs = append(errs, c.errorf(c.lineOf("Backend"), "unknown Backend %q", c.Backend))
+	}
+	if c.GitTimeout != "" {
+		if err := validInterval(c.GitTimeout); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("GitTimeout"), "GitTimeout: %v", err))
+		}
+	}
+
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, c.errorf(c.lineOf("LogLevel"), "%v", err))
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "", "logfmt", "text", "json":
+	default:
+		errs = append(errs, c.errorf(c.lineOf("LogFormat"), "unknown log format %q", c.LogFormat))
+	}
+
+	return errors.Join(errs...)
 }
 
+func (c *Config) errorf(line int, format string, args ...interface{}) error {
+	return &ConfigError{File: c.path, Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// lineAt returns the line number of a byte offset in the config file.
+func (c *Config) lineAt(offset int64) int {
+	if offset > int64(len(c.data)) {
+		offset = int64(len(c.data))
+	}
+
+	return bytes.Count(c.data[:offset], []byte("\n")) + 1
+}
+
+// lineOf returns the line on which key is set in the config file, or zero.
+// It works for JSON, YAML and TOML alike since they all put the key first.
+func (c *Config) lineOf(key string) int {
+	exp := regexp.MustCompile(`(?mi)^\s*["']?` + regexp.QuoteMeta(key) + `["']?\s*[:=]`)
+	loc := exp.FindIndex(c.data)
+	if loc == nil {
+		return 0
+	}
+
+	return c.lineAt(int64(loc[0]))
+}
+
+// lineInMessage pulls the line number out of a yaml or toml error message.
+func lineInMessage(msg string) int {
+	exp := regexp.MustCompile(`(?i)line (\d+)`)
+	m := exp.FindStringSubmatch(msg)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+
+	return n
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		env  map[string]string
+		// err is a part of the error, or empty when loading succeeds
+		err   string
+		check func(t *testing.T, conf *Config)
+	}{
+		{
+			name: "json",
+			file: "config.json",
+			data: `{
+  "RootDir": "$ROOT",
+  "Name": "Gist Bot",
+  "Email": "bot@example.com",
+  "Ignore": ["*.tmp"]
+}`,
+			check: func(t *testing.T, conf *Config) {
+				if conf.Name != "Gist Bot" || !slices.Equal(conf.Ignore, []string{"*.tmp"}) {
+					t.Errorf("got Name %q, Ignore %v", conf.Name, conf.Ignore)
+				}
+			},
+		},
+		{
+			name: "yaml",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nName: Gist Bot\nEmail: bot@example.com\nPullStrategy: rebase\n",
+			check: func(t *testing.T, conf *Config) {
+				if conf.pullStrategy() != StrategyRebase {
+					t.Errorf("got PullStrategy %q, want rebase", conf.PullStrategy)
+				}
+			},
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			data: "RootDir = \"$ROOT\"\nName = \"Gist Bot\"\nEmail = \"bot@example.com\"\n\n[Notify]\nInterval = \"1h\"\n",
+			check: func(t *testing.T, conf *Config) {
+				if conf.Notify.Interval != "1h" {
+					t.Errorf("got Notify.Interval %q, want 1h", conf.Notify.Interval)
+				}
+			},
+		},
+		{
+			name: "environment overrides the file",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nName: Gist Bot\nEmail: bot@example.com\n",
+			env:  map[string]string{"GISTBOT_NAME": "From Env", "GISTBOT_IGNORE": "*.tmp,*.bak"},
+			check: func(t *testing.T, conf *Config) {
+				if conf.Name != "From Env" || !slices.Equal(conf.Ignore, []string{"*.tmp", "*.bak"}) {
+					t.Errorf("got Name %q, Ignore %v", conf.Name, conf.Ignore)
+				}
+			},
+		},
+		{
+			name: "environment supplies a required field",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nName: Gist Bot\n",
+			env:  map[string]string{"GISTBOT_EMAIL": "env@example.com"},
+			check: func(t *testing.T, conf *Config) {
+				if conf.Email != "env@example.com" {
+					t.Errorf("got Email %q", conf.Email)
+				}
+			},
+		},
+		{
+			name: "home is expanded",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nName: Gist Bot\nEmail: bot@example.com\nStateFile: ~/state.json\n",
+			check: func(t *testing.T, conf *Config) {
+				if !filepath.IsAbs(conf.StateFile) || filepath.Base(conf.StateFile) != "state.json" {
+					t.Errorf("got StateFile %q", conf.StateFile)
+				}
+			},
+		},
+		{
+			name: "unknown json field has its line",
+			file: "config.json",
+			data: "{\n  \"RootDir\": \"$ROOT\",\n  \"Nmae\": \"Gist Bot\"\n}",
+			err:  `config.json:3: unknown field "Nmae"`,
+		},
+		{
+			name: "unknown yaml field",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nNmae: Gist Bot\n",
+			err:  "config.yaml:2:",
+		},
+		{
+			name: "unknown toml field",
+			file: "config.toml",
+			data: "RootDir = \"$ROOT\"\nNmae = \"Gist Bot\"\n",
+			err:  `config.toml:2: unknown field "Nmae"`,
+		},
+		{
+			name: "required fields",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\n",
+			err:  "Name is required",
+		},
+		{
+			name: "root is not a directory",
+			file: "config.yaml",
+			data: "RootDir: $ROOT/missing\nName: Gist Bot\nEmail: bot@example.com\n",
+			err:  "config.yaml:1: RootDir",
+		},
+		{
+			name: "unknown pull strategy",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nName: Gist Bot\nEmail: bot@example.com\nPullStrategy: squash\n",
+			err:  `config.yaml:4: unknown PullStrategy "squash"`,
+		},
+		{
+			name: "unknown backend",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nName: Gist Bot\nEmail: bot@example.com\nBackend: jgit\n",
+			err:  `unknown Backend "jgit"`,
+		},
+		{
+			name: "invalid environment value",
+			file: "config.yaml",
+			data: "RootDir: $ROOT\nName: Gist Bot\nEmail: bot@example.com\n",
+			env:  map[string]string{"GISTBOT_SQUASH": "sometimes"},
+			err:  "invalid GISTBOT_SQUASH",
+		},
+		{
+			name: "unsupported format",
+			file: "config.ini",
+			data: "RootDir = $ROOT\n",
+			err:  `unsupported config format ".ini"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("HOME", dir)
+			t.Setenv("ROOT", dir)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			path := filepath.Join(dir, tt.file)
+			writeFile(t, path, tt.data)
+
+			conf, err := NewConfig(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("NewConfig() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewConfig() error = %v", err)
+			}
+			if conf.Path() != path {
+				t.Errorf("Path() = %q, want %q", conf.Path(), path)
+			}
+			tt.check(t, conf)
+		})
+	}
+}
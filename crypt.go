@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Encrypted files are committed next to their plaintext with one of these
+// suffixes: .age when encrypted to AgeRecipients with the age tool, .enc
+// when encrypted with a key derived from the passphrase.
+const (
+	ageSuffix        = ".age"
+	passphraseSuffix = ".enc"
+)
+
+const (
+	// passphraseHeader starts a file encrypted with the passphrase, the rest
+	// is the base64 of the salt, the nonce and the sealed plaintext
+	passphraseHeader = "gistbot-encrypted v1\n"
+	pbkdf2Iterations = 600000
+	saltSize         = 16
+)
+
+// excludeComment heads the lines gistbot adds to .git/info/exclude.
+const excludeComment = "# encrypted by gistbot, the plaintext is never committed"
+
+// encrypts reports whether the file name is encrypted before it is
+// committed.
+func (c *Config) encrypts(name string) bool {
+	for _, pattern := range c.Encrypt {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encryptedSuffix is the suffix of the ciphertext files for this config.
+func (c *Config) encryptedSuffix() string {
+	if len(c.AgeRecipients) > 0 {
+		return ageSuffix
+	}
+
+	return passphraseSuffix
+}
+
+// isCiphertext reports whether the file name is one the bot encrypted.
+func (c *Config) isCiphertext(name string) bool {
+	if len(c.Encrypt) == 0 {
+		return false
+	}
+
+	for _, suffix := range []string{ageSuffix, passphraseSuffix} {
+		if strings.HasSuffix(name, suffix) && c.encrypts(strings.TrimSuffix(name, suffix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sealFiles encrypts every file in the repository that matches Encrypt into
+// its ciphertext file, and keeps the plaintext out of git. A file is only
+// encrypted again when its contents changed, so the ciphertext, and the
+// history, stays put otherwise. It returns the plaintext files.
+func (c *Config) sealFiles(repo string) ([]string, error) {
+	entries, err := os.ReadDir(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !c.encrypts(name) {
+			continue
+		}
+		sealed = append(sealed, name)
+
+		plaintext, err := os.ReadFile(filepath.Join(repo, name))
+		if err != nil {
+			return nil, err
+		}
+
+		cipherPath := filepath.Join(repo, name+c.encryptedSuffix())
+		if ciphertext, err := os.ReadFile(cipherPath); err == nil {
+			if current, err := c.decrypt(cipherPath, ciphertext); err == nil && bytes.Equal(current, plaintext) {
+				continue
+			}
+		}
+
+		ciphertext, err := c.encrypt(plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting %s: %v", name, err)
+		}
+		if err = os.WriteFile(cipherPath, ciphertext, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = excludeFiles(repo, sealed); err != nil {
+		return nil, err
+	}
+
+	return sealed, nil
+}
+
+// openFiles decrypts every ciphertext file in the repository to its
+// plaintext, e.g. after a pull brought in new ciphertext.
+func (c *Config) openFiles(repo string) error {
+	entries, err := os.ReadDir(repo)
+	if err != nil {
+		return err
+	}
+
+	opened := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !c.isCiphertext(name) {
+			continue
+		}
+
+		cipherPath := filepath.Join(repo, name)
+		ciphertext, err := os.ReadFile(cipherPath)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := c.decrypt(cipherPath, ciphertext)
+		if err != nil {
+			return fmt.Errorf("error decrypting %s: %v", name, err)
+		}
+
+		plainName := strings.TrimSuffix(name, filepath.Ext(name))
+		opened = append(opened, plainName)
+
+		plainPath := filepath.Join(repo, plainName)
+		if current, err := os.ReadFile(plainPath); err == nil && bytes.Equal(current, plaintext) {
+			continue
+		}
+		// Secrets are for the user's eyes only
+		if err = os.WriteFile(plainPath, plaintext, 0600); err != nil {
+			return err
+		}
+	}
+
+	return excludeFiles(repo, opened)
+}
+
+func (c *Config) encrypt(plaintext []byte) ([]byte, error) {
+	if len(c.AgeRecipients) > 0 {
+		args := []string{"--encrypt", "--armor"}
+		for _, recipient := range c.AgeRecipients {
+			args = append(args, "--recipient", recipient)
+		}
+		return pipeCommand(plaintext, "age", args...)
+	}
+
+	passphrase, err := c.passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append(append(salt, nonce...), aead.Seal(nil, nonce, plaintext, nil)...)
+	return []byte(passphraseHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+func (c *Config) decrypt(path string, ciphertext []byte) ([]byte, error) {
+	if strings.HasSuffix(path, ageSuffix) {
+		if c.AgeIdentity == "" {
+			return nil, errors.New("AgeIdentity is required to decrypt age files")
+		}
+		return pipeCommand(ciphertext, "age", "--decrypt", "--identity", c.AgeIdentity)
+	}
+
+	encoded, ok := bytes.CutPrefix(ciphertext, []byte(passphraseHeader))
+	if !ok {
+		return nil, errors.New("not a gistbot encrypted file")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := c.passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < saltSize {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	aead, err := passphraseCipher(passphrase, sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = sealed[saltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted file")
+	}
+
+	return plaintext, nil
+}
+
+// passphrase returns Passphrase, or else the output of PassphraseCommand,
+// which can ask a password manager or keyring.
+func (c *Config) passphrase() (string, error) {
+	if c.Passphrase != "" {
+		return c.Passphrase, nil
+	}
+	if c.PassphraseCommand == "" {
+		return "", errors.New("Passphrase or PassphraseCommand is required")
+	}
+
+	out, err := exec.Command("sh", "-c", c.PassphraseCommand).Output()
+	if err != nil {
+		return "", fmt.Errorf("error running PassphraseCommand: %v", err)
+	}
+
+	passphrase := strings.TrimRight(string(out), "\r\n")
+	if passphrase == "" {
+		return "", errors.New("PassphraseCommand printed no passphrase")
+	}
+
+	return passphrase, nil
+}
+
+func passphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// excludeFiles adds the files to .git/info/exclude so the plaintext is never
+// staged.
+func excludeFiles(repo string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(repo, ".git", "info", "exclude")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	missing := make([]string, 0)
+	for _, name := range names {
+		if line := "/" + name; !slices.Contains(lines, line) {
+			missing = append(missing, line)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	if !slices.Contains(lines, excludeComment) {
+		missing = append([]string{excludeComment}, missing...)
+	}
+	data = append(data, strings.Join(missing, "\n")+"\n"...)
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// validateEncryption checks the encryption settings.
+func (c *Config) validateEncryption() []error {
+	errs := make([]error, 0)
+
+	for _, pattern := range c.Encrypt {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("Encrypt"), "Encrypt: bad pattern %q", pattern))
+		}
+	}
+	if len(c.Encrypt) == 0 {
+		return errs
+	}
+
+	if len(c.AgeRecipients) > 0 {
+		if _, err := exec.LookPath("age"); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("AgeRecipients"), "AgeRecipients: age is not installed"))
+		}
+		// Pulls are decrypted, and saves are compared with what is
+		// committed, so a machine that can only encrypt cannot sync
+		switch _, err := os.Stat(c.AgeIdentity); {
+		case c.AgeIdentity == "":
+			errs = append(errs, c.errorf(c.lineOf("AgeRecipients"), "AgeRecipients needs AgeIdentity to decrypt with"))
+		case err != nil:
+			errs = append(errs, c.errorf(c.lineOf("AgeIdentity"), "AgeIdentity: %v", err))
+		}
+	} else if c.Passphrase == "" && c.PassphraseCommand == "" {
+		errs = append(errs, c.errorf(c.lineOf("Encrypt"), "Encrypt needs AgeRecipients, Passphrase or PassphraseCommand"))
+	}
+
+	return errs
+}
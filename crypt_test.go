@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateEncryptionAgeIdentity(t *testing.T) {
+	identity := filepath.Join(t.TempDir(), "key.txt")
+	writeFile(t, identity, "AGE-SECRET-KEY-1\n")
+
+	tests := []struct {
+		name     string
+		identity string
+		// err is a part of an error about AgeIdentity, or empty for none
+		err string
+	}{
+		{name: "recipients only", err: "AgeRecipients needs AgeIdentity"},
+		{name: "missing identity file", identity: identity + ".missing", err: "AgeIdentity: "},
+		{name: "identity", identity: identity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{
+				Encrypt:       []string{".netrc"},
+				AgeRecipients: []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"},
+				AgeIdentity:   tt.identity,
+			}
+
+			// age itself may not be installed, only the identity matters here
+			var identityErr error
+			for _, err := range conf.validateEncryption() {
+				if strings.Contains(err.Error(), "AgeIdentity") {
+					identityErr = errors.Join(identityErr, err)
+				}
+			}
+
+			switch {
+			case tt.err == "" && identityErr != nil:
+				t.Errorf("validateEncryption() = %v, want no AgeIdentity error", identityErr)
+			case tt.err != "" && (identityErr == nil || !strings.Contains(identityErr.Error(), tt.err)):
+				t.Errorf("validateEncryption() = %v, want %q", identityErr, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Link modes, picked per repository with LinkMode. Links are symlinks by
+// default; copies suit tools that will not follow a symlink.
+const (
+	LinkSymlink = "symlink"
+	LinkCopy    = "copy"
+)
+
+// Link health, as shown by the status command.
+const (
+	LinkOK       = "ok"
+	LinkMissing  = "missing"
+	LinkConflict = "conflict"
+	LinkStale    = "stale"
+	LinkBroken   = "broken"
+	// LinkEdited is a rendered template that was changed by hand
+	LinkEdited = "edited"
+)
+
+// templateSuffix marks gist files rendered with text/template on deploy.
+const templateSuffix = ".tmpl"
+
+// backupSuffix is added to a file that is in the way of a link.
+const backupSuffix = ".gistbot-backup"
+
+// Link is one file of a gist deployed into place.
+type Link struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Health string `json:"health"`
+}
+
+// links lists the links configured for the repository at path, sorted by
+// target.
+func (c *Config) links(path string) []Link {
+	repo := c.repoConfig(path)
+	if repo == nil {
+		return nil
+	}
+
+	links := make([]Link, 0, len(repo.Links))
+	for source, target := range repo.Links {
+		links = append(links, Link{Source: filepath.Join(path, source), Target: expandPath(target)})
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Target < links[j].Target })
+
+	return links
+}
+
+func (c *Config) linkMode(path string) string {
+	repo := c.repoConfig(path)
+	if repo == nil || repo.LinkMode == "" {
+		return LinkSymlink
+	}
+
+	return repo.LinkMode
+}
+
+// Deploy puts every linked file of the repository at path in place. A file
+// that is in the way is moved aside first. copies records what was copied
+// or rendered where, so a file the bot made can be replaced without a
+// backup; it is updated in place.
+//
+// Files ending in .tmpl are rendered instead. A rendered file that was edited
+// by hand is left alone and reported with a *ConflictError.
+func Deploy(conf *Config, path string, copies map[string]string) []error {
+	errs := make([]error, 0)
+	mode := conf.linkMode(path)
+	facts := conf.templateFacts(path)
+
+	for _, link := range conf.links(path) {
+		var err error
+		switch {
+		case strings.HasSuffix(link.Source, templateSuffix):
+			err = deployTemplate(link, copies, facts)
+		case mode == LinkCopy:
+			err = deployCopy(link, copies)
+		default:
+			err = deploySymlink(link)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error deploying %s to %s: %w", link.Source, link.Target, err))
+		}
+	}
+
+	return errs
+}
+
+func deploySymlink(link Link) error {
+	if dest, err := os.Readlink(link.Target); err == nil && dest == link.Source {
+		return nil
+	}
+	if _, err := os.Stat(link.Source); err != nil {
+		return err
+	}
+
+	if err := moveAside(link.Target); err != nil {
+		return err
+	}
+
+	slog.Info("linking", "file", link.Source, "target", link.Target)
+	return os.Symlink(link.Source, link.Target)
+}
+
+func deployCopy(link Link, copies map[string]string) error {
+	data, err := os.ReadFile(link.Source)
+	if err != nil {
+		return err
+	}
+	sum := checksum(data)
+
+	current, err := os.ReadFile(link.Target)
+	switch {
+	case err == nil && bytes.Equal(current, data):
+		copies[link.Target] = sum
+		return nil
+
+	case err == nil && checksum(current) == copies[link.Target]:
+		// An older copy of ours, safe to overwrite
+
+	case errors.Is(err, fs.ErrNotExist):
+		if err = os.MkdirAll(filepath.Dir(link.Target), 0755); err != nil {
+			return err
+		}
+
+	default:
+		if err = moveAside(link.Target); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(link.Source)
+	if err != nil {
+		return err
+	}
+
+	// Replace the target in one step, and never write through a symlink
+	slog.Info("copying", "file", link.Source, "target", link.Target)
+	tmp := link.Target + ".gistbot-tmp"
+	if err = os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, link.Target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	copies[link.Target] = sum
+	return nil
+}
+
+// deployTemplate renders the template at link.Source to link.Target.
+func deployTemplate(link Link, copies map[string]string, facts *TemplateFacts) error {
+	out, err := renderTemplate(link.Source, facts)
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(link.Target)
+	switch {
+	case err == nil && bytes.Equal(current, out):
+		copies[link.Target] = checksum(out)
+		return nil
+
+	case err == nil && checksum(current) == copies[link.Target]:
+		// An older rendering, safe to overwrite
+
+	case errors.Is(err, fs.ErrNotExist):
+
+	case err == nil && copies[link.Target] == "":
+		// Never rendered here, e.g. a .gitconfig from before the gist
+		if err = moveAside(link.Target); err != nil {
+			return err
+		}
+
+	case err == nil:
+		// Edited by hand, the edit belongs in the template
+		return &ConflictError{Paths: []string{link.Target}}
+
+	default:
+		return err
+	}
+
+	slog.Info("rendering", "file", link.Source, "target", link.Target)
+	if err = replaceFile(link.Target, out, link.Source); err != nil {
+		return err
+	}
+
+	copies[link.Target] = checksum(out)
+	return nil
+}
+
+// moveAside renames whatever is at path to a backup next to it, so a link
+// can take its place.
+func moveAside(path string) error {
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return os.MkdirAll(filepath.Dir(path), 0755)
+	}
+
+	backup := path + backupSuffix
+	if _, err := os.Lstat(backup); err == nil {
+		backup += "." + time.Now().Format("20060102150405")
+	}
+
+	slog.Warn("moving file out of the way of a link", "file", path, "backup", backup)
+	return os.Rename(path, backup)
+}
+
+// LinkHealth reports on each link of the repository at path without
+// changing anything.
+func LinkHealth(conf *Config, path string, copies map[string]string) []Link {
+	links := conf.links(path)
+	mode := conf.linkMode(path)
+	facts := conf.templateFacts(path)
+
+	for i := range links {
+		links[i].Health = linkHealth(links[i], mode, copies, facts)
+	}
+
+	return links
+}
+
+func linkHealth(link Link, mode string, copies map[string]string, facts *TemplateFacts) string {
+	template := strings.HasSuffix(link.Source, templateSuffix)
+
+	data, err := os.ReadFile(link.Source)
+	if template && err == nil {
+		data, err = renderTemplate(link.Source, facts)
+	}
+	if err != nil {
+		return LinkBroken
+	}
+
+	if _, err = os.Lstat(link.Target); err != nil {
+		return LinkMissing
+	}
+
+	if mode == LinkSymlink && !template {
+		if dest, err := os.Readlink(link.Target); err == nil && dest == link.Source {
+			return LinkOK
+		}
+		return LinkConflict
+	}
+
+	current, err := os.ReadFile(link.Target)
+	switch {
+	case err == nil && bytes.Equal(current, data):
+		return LinkOK
+	case err == nil && checksum(current) == copies[link.Target]:
+		return LinkStale
+	case template && copies[link.Target] != "":
+		return LinkEdited
+	}
+
+	return LinkConflict
+}
+
+// linkSummary counts links by health, e.g. "2 ok, 1 conflict".
+func linkSummary(links []Link) string {
+	if len(links) == 0 {
+		return "-"
+	}
+
+	counts := make(map[string]int)
+	for _, link := range links {
+		counts[link.Health]++
+	}
+
+	parts := make([]string, 0, len(counts))
+	for _, health := range []string{LinkOK, LinkMissing, LinkStale, LinkEdited, LinkConflict, LinkBroken} {
+		if counts[health] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[health], health))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// deploy deploys the links of repo and records the copies it made.
+func (b *Bot) deploy(repo string) {
+	conf := b.config()
+	if len(conf.links(repo)) == 0 {
+		return
+	}
+
+	state, err := LoadState(b.statePath)
+	if err != nil {
+		slog.Error("error loading state", "repo", repo, "err", err)
+		return
+	}
+
+	var deployed map[string]string
+	if rs, ok := state.Repos[repo]; ok {
+		deployed = rs.Copies
+	}
+
+	copies := maps.Clone(deployed)
+	if copies == nil {
+		copies = make(map[string]string)
+	}
+
+	for _, err := range Deploy(conf, repo, copies) {
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			slog.Warn("rendered file was edited by hand, not overwriting", "repo", repo, "file", conflict.Paths[0])
+			b.notifier.Notify(NotifyConflict, repo, err)
+			continue
+		}
+		slog.Error("error deploying link", "repo", repo, "err", err)
+	}
+
+	if maps.Equal(deployed, copies) {
+		return
+	}
+
+	_, err = UpdateState(b.statePath, func(s *State) error {
+		s.Repo(repo).Copies = copies
+		return nil
+	})
+	if err != nil {
+		slog.Error("error saving state", "repo", repo, "err", err)
+	}
+}
+
+// validateLinks checks the links of each repository.
+func (c *Config) validateLinks() []error {
+	errs := make([]error, 0)
+
+	for name, repo := range c.Repos {
+		if repo == nil {
+			continue
+		}
+
+		switch repo.LinkMode {
+		case "", LinkSymlink, LinkCopy:
+		default:
+			errs = append(errs, c.errorf(c.lineOf("Repos"), "Repos.%s: unknown LinkMode %q", name, repo.LinkMode))
+		}
+
+		for source, target := range repo.Links {
+			if !filepath.IsLocal(source) {
+				errs = append(errs, c.errorf(c.lineOf("Repos"), "Repos.%s.Links: %q is not a file in the gist", name, source))
+			}
+			if !filepath.IsAbs(expandPath(target)) {
+				errs = append(errs, c.errorf(c.lineOf("Repos"), "Repos.%s.Links: target %q must be an absolute path", name, target))
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeployTemplate(t *testing.T) {
+	const rendered = "[user]\n\temail = me@work.example\n"
+
+	tests := []struct {
+		name string
+		// current is what is at the target before deploying, if anything
+		current string
+		// recorded is the last rendering the bot recorded, if any
+		recorded string
+		// want is what is at the target after, backup what was moved aside
+		want     string
+		backup   string
+		conflict bool
+	}{
+		{name: "missing target", want: rendered},
+		{name: "file from before the gist", current: "[user]\n\temail = old@example\n", want: rendered, backup: "[user]\n\temail = old@example\n"},
+		{name: "older rendering", current: "[user]\n\temail = me@example\n", recorded: "[user]\n\temail = me@example\n", want: rendered},
+		{name: "edited by hand", current: "[user]\n\temail = edited@example\n", recorded: "[user]\n\temail = me@example\n", want: "[user]\n\temail = edited@example\n", conflict: true},
+		{name: "up to date", current: rendered, want: rendered},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "gist", "gitconfig.tmpl")
+			writeFile(t, source, "[user]\n\temail = {{.Vars.email}}\n")
+			target := filepath.Join(dir, "home", ".gitconfig")
+
+			copies := make(map[string]string)
+			if tt.current != "" {
+				writeFile(t, target, tt.current)
+			}
+			if tt.recorded != "" {
+				copies[target] = checksum([]byte(tt.recorded))
+			}
+
+			facts := &TemplateFacts{Vars: map[string]string{"email": "me@work.example"}}
+			err := deployTemplate(Link{Source: source, Target: target}, copies, facts)
+
+			var conflict *ConflictError
+			if tt.conflict != errors.As(err, &conflict) {
+				t.Fatalf("deployTemplate() error = %v, want a conflict %t", err, tt.conflict)
+			}
+			if !tt.conflict && err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readFile(t, target); got != tt.want {
+				t.Errorf("target = %q, want %q", got, tt.want)
+			}
+			backup, err := os.ReadFile(target + backupSuffix)
+			switch {
+			case tt.backup == "" && err == nil:
+				t.Errorf("unexpected backup %q", backup)
+			case tt.backup != "" && string(backup) != tt.backup:
+				t.Errorf("backup = %q, %v, want %q", backup, err, tt.backup)
+			}
+			if !tt.conflict && copies[target] != checksum([]byte(rendered)) {
+				t.Error("the rendering was not recorded")
+			}
+		})
+	}
+}
+
+func TestDeployTemplateAgain(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "gist", "gitconfig.tmpl")
+	writeFile(t, source, "email = {{.Vars.email}}\n")
+	target := filepath.Join(dir, "home", ".gitconfig")
+	writeFile(t, target, "email = old@example\n")
+
+	// A target that was there first is backed up once, after that the
+	// rendering is ours
+	copies := make(map[string]string)
+	link := Link{Source: source, Target: target}
+	for _, email := range []string{"one@example", "two@example"} {
+		if err := deployTemplate(link, copies, &TemplateFacts{Vars: map[string]string{"email": email}}); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, target); got != "email = "+email+"\n" {
+			t.Errorf("target = %q", got)
+		}
+	}
+	if got := readFile(t, target+backupSuffix); got != "email = old@example\n" {
+		t.Errorf("backup = %q", got)
+	}
+	if health := linkHealth(link, LinkSymlink, copies, &TemplateFacts{Vars: map[string]string{"email": "two@example"}}); health != LinkOK {
+		t.Errorf("health = %s, want ok", health)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log/slog"
 	"os"
 	"path/filepath"
 )
@@ -14,8 +15,17 @@ func NewFinder(conf *Config) *Finder {
 }
 
 func (f *Finder) Find() ([]string, error) {
-	// Leave room to hook into multiple directories or other config options
-	return f.find(f.conf.RootDir)
+	gitDirectories := make([]string, 0)
+
+	for _, root := range f.conf.Roots() {
+		dirs, err := f.find(root)
+		if err != nil {
+			return nil, err
+		}
+		gitDirectories = append(gitDirectories, dirs...)
+	}
+
+	return gitDirectories, nil
 }
 
 func (f *Finder) find(rootDir string) ([]string, error) {
@@ -30,6 +40,7 @@ func (f *Finder) find(rootDir string) ([]string, error) {
 			if err != nil {
 				return err
 			}
+			slog.Debug("found repository", "repo", absPath)
 			gitDirectories = append(gitDirectories, absPath)
 			return filepath.SkipDir
 		}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFinder(t *testing.T) {
+	tests := []struct {
+		name string
+		// roots are RootDir followed by RootDirs
+		roots []string
+		dirs  []string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "no repositories",
+			roots: []string{"gists"},
+			dirs:  []string{"gists/notes"},
+			want:  []string{},
+		},
+		{
+			name:  "repositories side by side",
+			roots: []string{"gists"},
+			dirs:  []string{"gists/b/.git", "gists/a/.git"},
+			want:  []string{"gists/a", "gists/b"},
+		},
+		{
+			name:  "nested under plain directories",
+			roots: []string{"gists"},
+			dirs:  []string{"gists/work/dotfiles/.git"},
+			want:  []string{"gists/work/dotfiles"},
+		},
+		{
+			name:  "git directory is not searched",
+			roots: []string{"gists"},
+			dirs:  []string{"gists/a/.git/modules/m/.git"},
+			want:  []string{"gists/a"},
+		},
+		{
+			name:  "git file is not a repository",
+			roots: []string{"gists"},
+			dirs:  []string{"gists/worktree"},
+			files: []string{"gists/worktree/.git"},
+			want:  []string{},
+		},
+		{
+			name:  "extra roots",
+			roots: []string{"gists", "more"},
+			dirs:  []string{"gists/a/.git", "more/b/.git"},
+			want:  []string{"gists/a", "more/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, d := range tt.dirs {
+				if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, f := range tt.files {
+				writeFile(t, filepath.Join(dir, f), "gitdir: elsewhere\n")
+			}
+
+			roots := make([]string, 0, len(tt.roots))
+			for _, root := range tt.roots {
+				roots = append(roots, filepath.Join(dir, root))
+			}
+			want := make([]string, 0, len(tt.want))
+			for _, w := range tt.want {
+				want = append(want, filepath.Join(dir, w))
+			}
+
+			conf := &Config{RootDir: roots[0], RootDirs: roots[1:]}
+			got, err := NewFinder(conf).Find()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("Find() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFinderMissingRoot(t *testing.T) {
+	conf := &Config{RootDir: filepath.Join(t.TempDir(), "missing")}
+	if _, err := NewFinder(conf).Find(); err == nil {
+		t.Error("Find() on a missing root succeeded, want an error")
+	}
+}
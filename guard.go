@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Gists are meant for small text files. Files over these sizes, and binary
+// files, are left unstaged unless the config says otherwise.
+const (
+	defaultMaxFileSize   = 10 << 20
+	defaultMaxCommitSize = 50 << 20
+)
+
+// binarySniffSize is how much of a file is searched for a NUL byte, the
+// same heuristic git uses to tell binary files apart.
+const binarySniffSize = 8000
+
+// Guardrails limit what the bot stages. A zero size is no limit.
+type Guardrails struct {
+	MaxFileSize   int64
+	MaxCommitSize int64
+	AllowBinary   bool
+}
+
+// guardrails returns the limits for the repository at path. A limit set for
+// the repository replaces the global one.
+func (c *Config) guardrails(path string) Guardrails {
+	g := Guardrails{
+		MaxFileSize:   sizeOr(c.MaxFileSize, defaultMaxFileSize),
+		MaxCommitSize: sizeOr(c.MaxCommitSize, defaultMaxCommitSize),
+		AllowBinary:   c.AllowBinary,
+	}
+
+	if repo := c.repoConfig(path); repo != nil {
+		g.MaxFileSize = sizeOr(repo.MaxFileSize, g.MaxFileSize)
+		g.MaxCommitSize = sizeOr(repo.MaxCommitSize, g.MaxCommitSize)
+		g.AllowBinary = g.AllowBinary || repo.AllowBinary
+	}
+
+	return g
+}
+
+// HeldFile is a file the guardrails kept out of a commit.
+type HeldFile struct {
+	Name   string
+	Size   int64
+	Reason string
+}
+
+// check picks the files of the repository at repo that must not be
+// committed. Within the commit limit the smallest files go first, so one
+// large file does not hold back the rest.
+func (g Guardrails) check(repo string, files []string) ([]HeldFile, error) {
+	held := make([]HeldFile, 0)
+	sizes := make(map[string]int64)
+
+	for _, name := range files {
+		info, err := os.Lstat(filepath.Join(repo, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted, nothing to stage
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		switch {
+		case g.MaxFileSize > 0 && info.Size() > g.MaxFileSize:
+			held = append(held, HeldFile{Name: name, Size: info.Size(), Reason: "larger than MaxFileSize " + formatSize(g.MaxFileSize)})
+			continue
+		case !g.AllowBinary:
+			binary, err := isBinary(filepath.Join(repo, name))
+			if err != nil {
+				return nil, err
+			}
+			if binary {
+				held = append(held, HeldFile{Name: name, Size: info.Size(), Reason: "binary"})
+				continue
+			}
+		}
+		sizes[name] = info.Size()
+	}
+
+	if g.MaxCommitSize == 0 {
+		return held, nil
+	}
+
+	names := make([]string, 0, len(sizes))
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if sizes[names[i]] != sizes[names[j]] {
+			return sizes[names[i]] < sizes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var total int64
+	for _, name := range names {
+		if total+sizes[name] > g.MaxCommitSize {
+			held = append(held, HeldFile{Name: name, Size: sizes[name], Reason: "commit would be larger than MaxCommitSize " + formatSize(g.MaxCommitSize)})
+			continue
+		}
+		total += sizes[name]
+	}
+
+	return held, nil
+}
+
+// isBinary reports whether the file at path has a NUL byte near its start.
+func isBinary(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+
+	return bytes.IndexByte(head[:n], 0) >= 0, nil
+}
+
+// sizeUnits are the suffixes parseSize understands, longest first.
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize reads a size like 512KB or 10MB, in powers of 1024. "none" is
+// no limit and reads as zero.
+func parseSize(value string) (int64, error) {
+	number := strings.TrimSpace(value)
+	if number == "none" {
+		return 0, nil
+	}
+
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(number, u.suffix); ok {
+			number, unit = strings.TrimSpace(n), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 512KB, 10MB or none", value)
+	}
+
+	return n * unit, nil
+}
+
+// sizeOr parses value, falling back to def when it is unset or invalid.
+func sizeOr(value string, def int64) int64 {
+	if value == "" {
+		return def
+	}
+
+	size, err := parseSize(value)
+	if err != nil {
+		return def
+	}
+
+	return size
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30 && size%(1<<30) == 0:
+		return fmt.Sprintf("%dGB", size>>30)
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", size>>20)
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", size>>10)
+	}
+
+	return fmt.Sprintf("%dB", size)
+}
+
+// validateGuardrails checks the size limits, globally and per repository.
+func (c *Config) validateGuardrails() []error {
+	errs := make([]error, 0)
+
+	check := func(field, prefix, value string) {
+		if value == "" {
+			return
+		}
+		if _, err := parseSize(value); err != nil {
+			line := c.lineOf(field)
+			if prefix != "" {
+				line = c.lineOf("Repos")
+			}
+			errs = append(errs, c.errorf(line, "%s%s: %v", prefix, field, err))
+		}
+	}
+
+	check("MaxFileSize", "", c.MaxFileSize)
+	check("MaxCommitSize", "", c.MaxCommitSize)
+	for name, repo := range c.Repos {
+		if repo == nil {
+			continue
+		}
+		check("MaxFileSize", "Repos."+name+".", repo.MaxFileSize)
+		check("MaxCommitSize", "Repos."+name+".", repo.MaxCommitSize)
+	}
+
+	return errs
+}
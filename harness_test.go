@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isolateGit keeps the user's git config out of the tests, for the git
+// helpers and the backends alike.
+func isolateGit(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+}
+
+// runGit runs git in dir as a test user and returns its trimmed output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	out, err := gitOutput(dir, args...)
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+
+	return out
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	args = append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+
+	return strings.TrimSpace(string(out)), err
+}
+
+// testRemote is a bare origin with one commit on master, for the clones
+// under test to push to and pull from.
+type testRemote struct {
+	t    *testing.T
+	path string
+	// work is a clone used to make changes on the remote
+	work string
+}
+
+func newTestRemote(t *testing.T) *testRemote {
+	t.Helper()
+	isolateGit(t)
+
+	dir := t.TempDir()
+	r := &testRemote{t: t, path: filepath.Join(dir, "origin.git"), work: filepath.Join(dir, "work")}
+	runGit(t, dir, "init", "--quiet", "--bare", "--initial-branch=master", r.path)
+	runGit(t, dir, "init", "--quiet", "--initial-branch=master", r.work)
+	writeFile(t, filepath.Join(r.work, "README.md"), "# gist\n")
+	runGit(t, r.work, "add", "--all")
+	runGit(t, r.work, "commit", "--quiet", "-m", "Initial commit")
+	runGit(t, r.work, "remote", "add", "origin", r.path)
+	runGit(t, r.work, "push", "--quiet", "--set-upstream", "origin", "master")
+
+	return r
+}
+
+// clone checks out the remote at dir.
+func (r *testRemote) clone(dir string) string {
+	r.t.Helper()
+
+	runGit(r.t, filepath.Dir(dir), "clone", "--quiet", r.path, dir)
+	return dir
+}
+
+// commit changes a file on the remote as if from another machine, and
+// returns the new head.
+func (r *testRemote) commit(name, content string) string {
+	r.t.Helper()
+
+	runGit(r.t, r.work, "pull", "--quiet", "--ff-only", "origin", "master")
+	writeFile(r.t, filepath.Join(r.work, name), content)
+	runGit(r.t, r.work, "add", "--all")
+	runGit(r.t, r.work, "commit", "--quiet", "-m", "Change "+name)
+	runGit(r.t, r.work, "push", "--quiet", "origin", "master")
+
+	return r.head()
+}
+
+func (r *testRemote) head() string {
+	r.t.Helper()
+
+	return runGit(r.t, r.path, "rev-parse", "master")
+}
+
+// file returns the content of name on the remote master, or false when it
+// is not there.
+func (r *testRemote) file(name string) (string, bool) {
+	out, err := gitOutput(r.path, "show", "master:"+name)
+	if err != nil {
+		return "", false
+	}
+
+	return out, true
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// testConfig is a valid config for the repositories under root on the given
+// backend, with its state kept in a temp dir.
+func testConfig(t *testing.T, root, backend string) *Config {
+	t.Helper()
+
+	dir := t.TempDir()
+	conf := &Config{
+		RootDir:   root,
+		Name:      "Gist Bot",
+		Email:     "bot@example.com",
+		Backend:   backend,
+		StateFile: filepath.Join(dir, "state.json"),
+		path:      filepath.Join(dir, "config.json"),
+	}
+	if err := conf.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return conf
+}
+
+// forEachBackend runs test once per backend. Backends that are not built
+// in, like libgit2 without cgo, are skipped.
+func forEachBackend(t *testing.T, test func(t *testing.T, backend string)) {
+	for _, name := range []string{BackendGit, BackendLibgit2} {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := backends[name]; !ok {
+				t.Skipf("%s backend is not built in", name)
+			}
+			test(t, name)
+		})
+	}
+}
+
+// eventually waits for cond to hold, for the work the bot does on its own
+// goroutines.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
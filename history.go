@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// hostTrailer names the machine a commit was made on, see HostTrailer.
+const hostTrailer = "Gistbot-Host: "
+
+// FileVersion is one commit in the history of a file.
+type FileVersion struct {
+	Id      string
+	Author  Signature
+	Host    string
+	Summary string
+}
+
+// gitPath is the file committed for name, which is its ciphertext when name
+// is encrypted.
+func (r *Repository) gitPath(name string) string {
+	if r.conf.encrypts(filepath.Base(name)) {
+		return name + r.conf.encryptedSuffix()
+	}
+
+	return name
+}
+
+// History lists the commits that changed the file name, newest first.
+func (r *Repository) History(name string) ([]FileVersion, error) {
+	ids, err := r.git.FileLog(r.gitPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]FileVersion, 0, len(ids))
+	for _, id := range ids {
+		raw, err := r.git.CommitObject(id)
+		if err != nil {
+			return nil, err
+		}
+
+		author, err := parseSignature(commitHeader(raw, "author"))
+		if err != nil {
+			return nil, fmt.Errorf("error reading commit %s: %v", id, err)
+		}
+
+		_, message, _ := bytes.Cut(raw, []byte("\n\n"))
+		summary, _, _ := strings.Cut(string(message), "\n")
+
+		host := ""
+		for _, line := range strings.Split(string(message), "\n") {
+			if value, ok := strings.CutPrefix(line, hostTrailer); ok {
+				host = value
+			}
+		}
+
+		versions = append(versions, FileVersion{Id: id, Author: *author, Host: host, Summary: summary})
+	}
+
+	return versions, nil
+}
+
+// Diff shows what changed in the file name since rev.
+func (r *Repository) Diff(name, rev string) (string, error) {
+	if r.gitPath(name) != name {
+		return "", fmt.Errorf("%s is encrypted, only its ciphertext is in the history", name)
+	}
+
+	id, err := r.git.RevParse(rev)
+	if err != nil {
+		return "", err
+	}
+
+	return r.git.Diff(id, name)
+}
+
+// Restore writes the file name back as it was at rev. The file is written in
+// place, so the bot sees a save and commits it like any other.
+func (r *Repository) Restore(name, rev string) (string, error) {
+	id, err := r.git.RevParse(rev)
+	if err != nil {
+		return "", err
+	}
+
+	path := r.gitPath(name)
+	data, err := r.git.FileAt(id, path)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(r.path, name)
+	mode := fileMode(target, target)
+	if path != name {
+		if data, err = r.conf.decrypt(path, data); err != nil {
+			return "", fmt.Errorf("error decrypting %s: %v", path, err)
+		}
+		mode = 0600
+	}
+
+	if err = os.WriteFile(target, data, mode); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// logCommand lists the commits that changed a file.
+func logCommand(conf *Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s log <file>", os.Args[0])
+	}
+
+	repo, name, err := openFile(conf, args[0])
+	if err != nil {
+		return err
+	}
+
+	versions, err := repo.History(name)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMIT\tDATE\tHOST\tAUTHOR\tSUMMARY")
+	for _, v := range versions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", shortId(v.Id), v.Author.When.Format("2006-01-02 15:04"), orDash(v.Host), v.Author.Name, v.Summary)
+	}
+
+	return w.Flush()
+}
+
+// diffCommand shows what changed in a file since a commit.
+func diffCommand(conf *Config, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s diff <file> <rev>", os.Args[0])
+	}
+
+	repo, name, err := openFile(conf, args[0])
+	if err != nil {
+		return err
+	}
+
+	diff, err := repo.Diff(name, args[1])
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Print(diff)
+	return err
+}
+
+// restoreCommand writes an old version of a file back for the bot to commit.
+func restoreCommand(conf *Config, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s restore <file> <rev>", os.Args[0])
+	}
+
+	repo, name, err := openFile(conf, args[0])
+	if err != nil {
+		return err
+	}
+
+	id, err := repo.Restore(name, args[1])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s from %s\n", filepath.Join(repo.path, name), shortId(id))
+	return nil
+}
+
+// openFile opens the repository holding the file at path, and returns the
+// file's name within it.
+func openFile(conf *Config, path string) (*Repository, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	repos, err := NewFinder(conf).Find()
+	if err != nil {
+		return nil, "", fmt.Errorf("error finding repos %v", err)
+	}
+
+	// A deployed link like ~/.bashrc stands for the file in its gist
+	paths := []string{abs}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil && resolved != abs {
+		paths = append(paths, resolved)
+	}
+
+	for _, path := range paths {
+		for _, dir := range repos {
+			if name, err := filepath.Rel(dir, path); err == nil && filepath.IsLocal(name) {
+				repo, err := NewRepository(conf, dir)
+				return repo, filepath.ToSlash(name), err
+			}
+		}
+	}
+
+	return nil, "", fmt.Errorf("%s is not in a gist", path)
+}
+
+func shortId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+
+	return id
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultHookTimeout bounds a hook when Hooks.Timeout is not set.
+const defaultHookTimeout = 30 * time.Second
+
+// Hooks are shell commands run around commits and pushes. They run in the
+// repository with GISTBOT_HOOK, GISTBOT_REPO, GISTBOT_FILES (one per line)
+// and GISTBOT_COMMIT set. A failing PreCommit blocks the commit.
+type Hooks struct {
+	PreCommit  string `yaml:"PreCommit"`
+	PostCommit string `yaml:"PostCommit"`
+	PostPush   string `yaml:"PostPush"`
+	Timeout    string `yaml:"Timeout"`
+}
+
+// RepoConfig holds the settings for one repository, keyed in Config.Repos by
+// its path or directory name.
+type RepoConfig struct {
+	Hooks Hooks `yaml:"Hooks"`
+	// Links maps files in the gist to where they are deployed, e.g.
+	// bashrc to ~/.bashrc
+	Links    map[string]string `yaml:"Links"`
+	LinkMode string            `yaml:"LinkMode"`
+	// Mirror maps files in the gist to real files kept in sync with them
+	Mirror map[string]string `yaml:"Mirror"`
+	Vars   map[string]string `yaml:"Vars"`
+	// MaxFileSize, MaxCommitSize and AllowBinary override the global
+	// guardrails
+	MaxFileSize   string `yaml:"MaxFileSize"`
+	MaxCommitSize string `yaml:"MaxCommitSize"`
+	AllowBinary   bool   `yaml:"AllowBinary"`
+}
+
+// repoConfig returns the settings for the repository at path, or nil.
+func (c *Config) repoConfig(path string) *RepoConfig {
+	if repo, ok := c.Repos[path]; ok {
+		return repo
+	}
+
+	return c.Repos[filepath.Base(path)]
+}
+
+// hooks returns the hooks for the repository at path. A hook set for the
+// repository replaces the global one.
+func (c *Config) hooks(path string) Hooks {
+	hooks := c.Hooks
+
+	repo := c.repoConfig(path)
+	if repo == nil {
+		return hooks
+	}
+
+	for _, h := range []struct{ global, local *string }{
+		{&hooks.PreCommit, &repo.Hooks.PreCommit},
+		{&hooks.PostCommit, &repo.Hooks.PostCommit},
+		{&hooks.PostPush, &repo.Hooks.PostPush},
+		{&hooks.Timeout, &repo.Hooks.Timeout},
+	} {
+		if *h.local != "" {
+			*h.global = *h.local
+		}
+	}
+
+	return hooks
+}
+
+func (h Hooks) timeout() time.Duration {
+	timeout, err := time.ParseDuration(h.Timeout)
+	if err != nil {
+		return defaultHookTimeout
+	}
+
+	return timeout
+}
+
+// validateHooks checks the hook timeouts.
+func (c *Config) validateHooks() []error {
+	errs := make([]error, 0)
+
+	if c.Hooks.Timeout != "" {
+		if err := validInterval(c.Hooks.Timeout); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("Hooks"), "Hooks.Timeout: %v", err))
+		}
+	}
+	for name, repo := range c.Repos {
+		if repo == nil || repo.Hooks.Timeout == "" {
+			continue
+		}
+		if err := validInterval(repo.Hooks.Timeout); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("Repos"), "Repos.%s.Hooks.Timeout: %v", name, err))
+		}
+	}
+
+	return errs
+}
+
+// HookError is returned when a hook fails or runs out of time.
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.Hook, e.Err)
+}
+
+// runHook runs the hook command, if one is set, and returns a *HookError if
+// it fails or runs out of time.
+func (r *Repository) runHook(name, command string, files []string, commit string) error {
+	if command == "" {
+		return nil
+	}
+
+	var output bytes.Buffer
+	timeout := r.conf.hooks(r.path).timeout()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = r.path
+	cmd.Env = append(os.Environ(),
+		"GISTBOT_HOOK="+name,
+		"GISTBOT_REPO="+r.path,
+		"GISTBOT_FILES="+strings.Join(files, "\n"),
+		"GISTBOT_COMMIT="+commit)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	r.log.Debug("ran hook", "hook", name, "output", output.String())
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return &HookError{Hook: name, Err: fmt.Errorf("timed out after %v", timeout)}
+	}
+	if err != nil {
+		return &HookError{Hook: name, Err: fmt.Errorf("%v: %s", err, strings.TrimSpace(output.String()))}
+	}
+
+	return nil
+}
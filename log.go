@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logLevel is shared by every handler so the level can be changed without
+// rebuilding the logger.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging installs the structured logger used across the bot. Lines are
+// written as logfmt (the default) or JSON. When stderr is connected to the
+// journal the timestamp is dropped, journald records its own.
+func setupLogging(w io.Writer, level, format string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if os.Getenv("JOURNAL_STREAM") != "" {
+		opts.ReplaceAttr = dropTime
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "logfmt", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	logLevel.Set(lvl)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
+
+func dropTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+
+	return a
+}
@@ -1,31 +1,91 @@
 package main
 
 import (
-	"log"
 	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
 )
 
-var configFile = flag.String("config-file", "my_config.json", "The file path of your config file")
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var configFile = flag.String("config-file", "", "The file path of your config file (default $XDG_CONFIG_HOME/gistbot/config.{yaml,toml,json})")
 
 func main() {
-	ch := make(chan bool)
+	flag.Usage = usage
 	flag.Parse()
 
+	command, args := "run", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[command]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	// Keep the command line quiet until the config says otherwise
+	level := "warn"
+	if command == "run" {
+		level = "info"
+	}
+	setupLogging(os.Stderr, level, "logfmt")
+
 	// load the config
 	conf, err := NewConfig(*configFile)
 	if err != nil {
-		log.Fatal(err)
+		fatal("error loading config", err)
+	}
+
+	if err = setupLogging(os.Stderr, conf.LogLevel, conf.LogFormat); err != nil {
+		fatal("error configuring logging", err)
+	}
+
+	if err = cmd(conf, args); err != nil {
+		fatal("error running "+command, err)
 	}
+}
+
+func runBot(conf *Config, args []string) error {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 
 	bot, err := NewBot(conf)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	if err = bot.Start(); err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	sig := <-ch
+	slog.Info("shutting down", "signal", sig.String())
+	return bot.Stop()
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
-	defer bot.Stop()
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] [command] [args]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
 
-	<-ch
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Mirror is a file that lives outside the gist, for tools that replace their
+// config files and would break a link, with a copy kept in the gist.
+type Mirror struct {
+	// File is the copy in the gist checkout
+	File string
+	// Path is the real file
+	Path string
+}
+
+// mirrors lists the mirrored files of the repository at path.
+func (c *Config) mirrors(path string) []Mirror {
+	repo := c.repoConfig(path)
+	if repo == nil {
+		return nil
+	}
+
+	mirrors := make([]Mirror, 0, len(repo.Mirror))
+	for file, target := range repo.Mirror {
+		mirrors = append(mirrors, Mirror{File: filepath.Join(path, file), Path: filepath.Clean(expandPath(target))})
+	}
+	sort.Slice(mirrors, func(i, j int) bool { return mirrors[i].Path < mirrors[j].Path })
+
+	return mirrors
+}
+
+// syncMirror copies whichever side of m changed since the last sync over the
+// other. synced holds the checksum of each file as of its last sync, by
+// Path, and is updated. A *ConflictError is returned when both sides changed.
+func syncMirror(m Mirror, synced map[string]string) error {
+	inGist, gistErr := os.ReadFile(m.File)
+	outside, pathErr := os.ReadFile(m.Path)
+	for _, err := range []error{gistErr, pathErr} {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	base, seen := synced[m.Path]
+	switch {
+	case gistErr != nil && pathErr != nil:
+		return nil
+
+	case gistErr == nil && pathErr == nil && bytes.Equal(inGist, outside):
+		// In sync already
+
+	case gistErr != nil || (pathErr == nil && seen && checksum(inGist) == base):
+		// Only the real file changed. The copy is written in place so the
+		// save is seen and committed like any other
+		slog.Info("mirroring in", "file", m.Path, "target", m.File)
+		if err := os.WriteFile(m.File, outside, fileMode(m.File, m.Path)); err != nil {
+			return err
+		}
+		inGist = outside
+
+	case pathErr != nil || (seen && checksum(outside) == base):
+		// Only the gist changed, e.g. by a pull
+		slog.Info("mirroring out", "file", m.File, "target", m.Path)
+		if err := replaceFile(m.Path, inGist, m.File); err != nil {
+			return err
+		}
+
+	default:
+		return &ConflictError{Paths: []string{m.File, m.Path}}
+	}
+
+	synced[m.Path] = checksum(inGist)
+	return nil
+}
+
+// replaceFile writes data to path in one step, so the tool that owns the file
+// never reads half of it.
+func replaceFile(path string, data []byte, like string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".gistbot-tmp"
+	if err := os.WriteFile(tmp, data, fileMode(path, like)); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// fileMode returns the permissions of path, or else of like, for a file about
+// to be written.
+func fileMode(path, like string) fs.FileMode {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().Perm()
+	}
+	if info, err := os.Stat(like); err == nil {
+		return info.Mode().Perm()
+	}
+
+	return 0644
+}
+
+// mirror syncs the mirrored files of repo and records what was synced.
+func (b *Bot) mirror(repo string) {
+	conf := b.config()
+	mirrors := conf.mirrors(repo)
+	if len(mirrors) == 0 {
+		return
+	}
+
+	b.mirrorMu.Lock()
+	defer b.mirrorMu.Unlock()
+
+	state, err := LoadState(b.statePath)
+	if err != nil {
+		slog.Error("error loading state", "repo", repo, "err", err)
+		return
+	}
+
+	var prev map[string]string
+	if rs, ok := state.Repos[repo]; ok {
+		prev = rs.Mirrored
+	}
+
+	synced := maps.Clone(prev)
+	if synced == nil {
+		synced = make(map[string]string)
+	}
+
+	for _, m := range mirrors {
+		err := syncMirror(m, synced)
+
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			slog.Warn("mirrored file changed on both sides, leaving both alone", "repo", repo, "file", m.Path)
+			b.notifier.Notify(NotifyConflict, repo, err)
+		} else if err != nil {
+			slog.Error("error mirroring file", "repo", repo, "file", m.Path, "err", err)
+		}
+	}
+
+	if maps.Equal(prev, synced) {
+		return
+	}
+
+	_, err = UpdateState(b.statePath, func(s *State) error {
+		s.Repo(repo).Mirrored = synced
+		return nil
+	})
+	if err != nil {
+		slog.Error("error saving state", "repo", repo, "err", err)
+	}
+}
+
+// watchMirrors mirrors the real files into their gists as they change. The
+// directories are watched, not the files, since tools often replace a file
+// rather than write to it.
+func (b *Bot) watchMirrors() {
+	b.updateMirrorWatches()
+
+	for {
+		select {
+
+		case event, ok := <-b.mirrorWatcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if repo, ok := b.mirroredBy(event.Name); ok {
+				b.mirror(repo)
+			}
+
+		case err, ok := <-b.mirrorWatcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("error from the mirror watcher", "err", err)
+		}
+	}
+}
+
+// updateMirrorWatches watches the directories of the mirrored files of the
+// current repositories, and stops watching any others.
+func (b *Bot) updateMirrorWatches() {
+	b.mu.RLock()
+	conf, repos := b.conf, b.repos
+	b.mu.RUnlock()
+
+	dirs := make([]string, 0)
+	for _, repo := range repos {
+		for _, m := range conf.mirrors(repo) {
+			if dir := filepath.Dir(m.Path); !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	b.mirrorMu.Lock()
+	defer b.mirrorMu.Unlock()
+
+	added, removed := diffPaths(b.mirrorDirs, dirs)
+	for _, dir := range removed {
+		if err := b.mirrorWatcher.Remove(dir); err != nil {
+			slog.Error("error removing mirror watch", "file", dir, "err", err)
+		}
+	}
+	for _, dir := range added {
+		// The directory may not exist yet, it is created on the first sync
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			slog.Error("error creating directory", "file", dir, "err", err)
+		}
+		if err := b.mirrorWatcher.Add(dir); err != nil {
+			slog.Error("error watching mirrored files", "file", dir, "err", err)
+		}
+	}
+	b.mirrorDirs = dirs
+}
+
+// mirroredBy returns the repository that mirrors the file at path.
+func (b *Bot) mirroredBy(path string) (string, bool) {
+	b.mu.RLock()
+	conf, repos := b.conf, b.repos
+	b.mu.RUnlock()
+
+	for _, repo := range repos {
+		for _, m := range conf.mirrors(repo) {
+			if m.Path == path {
+				return repo, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// validateMirrors checks the mirrored files of each repository.
+func (c *Config) validateMirrors() []error {
+	errs := make([]error, 0)
+
+	for name, repo := range c.Repos {
+		if repo == nil {
+			continue
+		}
+
+		for file, target := range repo.Mirror {
+			if !filepath.IsLocal(file) {
+				errs = append(errs, c.errorf(c.lineOf("Repos"), "Repos.%s.Mirror: %q is not a file in the gist", name, file))
+			}
+			if !filepath.IsAbs(expandPath(target)) {
+				errs = append(errs, c.errorf(c.lineOf("Repos"), "Repos.%s.Mirror: %q must be an absolute path", name, target))
+			}
+			if _, ok := repo.Links[file]; ok {
+				errs = append(errs, c.errorf(c.lineOf("Repos"), "Repos.%s: %q cannot be both linked and mirrored", name, file))
+			}
+		}
+	}
+
+	return errs
+}
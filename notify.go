@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Kinds of notification.
+const (
+	NotifyPushFailed    = "push-failed"
+	NotifyPullFailed    = "pull-failed"
+	NotifyConflict      = "conflict"
+	NotifyCommitBlocked = "commit-blocked"
+	NotifyPaused        = "paused"
+	NotifyFilesHeld     = "files-held"
+)
+
+const (
+	// defaultNotifyInterval is how long the same notification for the same
+	// repository is held back after it is sent, when Notify.Interval is not
+	// set.
+	defaultNotifyInterval = 15 * time.Minute
+
+	// notifyTimeout bounds the delivery of one notification to one backend.
+	notifyTimeout = 10 * time.Second
+)
+
+// NotifyConfig picks where failures are reported. Any number of backends can
+// be on at once.
+type NotifyConfig struct {
+	Desktop  bool   `yaml:"Desktop"`
+	Webhook  string `yaml:"Webhook"`
+	Script   string `yaml:"Script"`
+	Interval string `yaml:"Interval"`
+}
+
+// Notification is one failure reported to the user. It is the JSON body
+// posted to the webhook.
+type Notification struct {
+	Kind    string    `json:"kind"`
+	Repo    string    `json:"repo"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier delivers notifications through one backend.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Notifiers sends notifications to the configured backends. Each kind of
+// notification is sent at most once per Interval for a repository, so a
+// failure repeating on every save does not spam anyone.
+type Notifiers struct {
+	clock Clock
+
+	mu       sync.Mutex
+	backends []Notifier
+	interval time.Duration
+	// sent is when each kind was last sent for each repository
+	sent map[string]time.Time
+}
+
+func NewNotifiers(conf *Config, clock Clock) *Notifiers {
+	n := &Notifiers{clock: clock, sent: make(map[string]time.Time)}
+	n.SetConfig(conf)
+
+	return n
+}
+
+// SetConfig swaps in the backends of a reloaded config. Rate limits carry
+// over.
+func (n *Notifiers) SetConfig(conf *Config) {
+	backends := make([]Notifier, 0)
+	if conf.Notify.Desktop {
+		backends = append(backends, desktopNotifier{})
+	}
+	if conf.Notify.Webhook != "" {
+		backends = append(backends, webhookNotifier{url: conf.Notify.Webhook})
+	}
+	if conf.Notify.Script != "" {
+		backends = append(backends, scriptNotifier{command: conf.Notify.Script})
+	}
+
+	interval, err := time.ParseDuration(conf.Notify.Interval)
+	if err != nil {
+		interval = defaultNotifyInterval
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.backends = backends
+	n.interval = interval
+}
+
+// Notify reports a failure in the background unless the same failure was
+// reported for repo within the interval.
+func (n *Notifiers) Notify(kind, repo string, err error) {
+	now := n.clock.Now()
+	key := kind + "\x00" + repo
+
+	n.mu.Lock()
+	if last, ok := n.sent[key]; ok && now.Sub(last) < n.interval {
+		n.mu.Unlock()
+		slog.Debug("notification rate limited", "kind", kind, "repo", repo)
+		return
+	}
+	n.sent[key] = now
+	backends := n.backends
+	n.mu.Unlock()
+
+	notification := Notification{Kind: kind, Repo: repo, Message: err.Error(), Time: now}
+	for _, backend := range backends {
+		go func(backend Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+
+			if err := backend.Notify(ctx, notification); err != nil {
+				slog.Error("error sending notification", "kind", kind, "repo", repo, "err", err)
+			}
+		}(backend)
+	}
+}
+
+// desktopNotifier shows a freedesktop notification over the session D-Bus.
+type desktopNotifier struct{}
+
+func (desktopNotifier) Notify(ctx context.Context, n Notification) error {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error connecting to the session bus: %v", err)
+	}
+	defer conn.Close()
+
+	summary := fmt.Sprintf("gistbot: %s", strings.ReplaceAll(n.Kind, "-", " "))
+	body := fmt.Sprintf("%s\n%s", n.Repo, n.Message)
+
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.CallWithContext(ctx, "org.freedesktop.Notifications.Notify", 0,
+		"gistbot", uint32(0), "dialog-warning", summary, body,
+		[]string{}, map[string]dbus.Variant{}, int32(-1))
+	if call.Err != nil {
+		return fmt.Errorf("error showing notification: %v", call.Err)
+	}
+
+	return nil
+}
+
+// webhookNotifier posts the notification as JSON.
+type webhookNotifier struct {
+	url string
+}
+
+func (w webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// scriptNotifier runs a shell command with the notification in
+// GISTBOT_EVENT, GISTBOT_REPO and GISTBOT_MESSAGE.
+type scriptNotifier struct {
+	command string
+}
+
+func (s scriptNotifier) Notify(ctx context.Context, n Notification) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.command)
+	cmd.Env = append(os.Environ(),
+		"GISTBOT_EVENT="+n.Kind,
+		"GISTBOT_REPO="+n.Repo,
+		"GISTBOT_MESSAGE="+n.Message)
+	cmd.WaitDelay = time.Second
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("notify script failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// validateNotify checks the notification settings.
+func (c *Config) validateNotify() []error {
+	errs := make([]error, 0)
+
+	if c.Notify.Webhook != "" {
+		if u, err := url.Parse(c.Notify.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, c.errorf(c.lineOf("Notify"), "Notify.Webhook: invalid URL %q", c.Notify.Webhook))
+		}
+	}
+	if c.Notify.Interval != "" {
+		if err := validInterval(c.Notify.Interval); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("Notify"), "Notify.Interval: %v", err))
+		}
+	}
+
+	return errs
+}
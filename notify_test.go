@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	received := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("error decoding payload: %v", err)
+		}
+		received <- payload
+	}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	conf := &Config{Notify: NotifyConfig{Webhook: srv.URL}}
+	NewNotifiers(conf, clock).Notify(NotifyPushFailed, "/gists/notes", errors.New("remote hung up"))
+
+	select {
+	case payload := <-received:
+		want := map[string]any{
+			"kind":    NotifyPushFailed,
+			"repo":    "/gists/notes",
+			"message": "remote hung up",
+			"time":    clock.Now().Format(time.RFC3339Nano),
+		}
+		for key, value := range want {
+			if payload[key] != value {
+				t.Errorf("payload %s = %v, want %v", key, payload[key], value)
+			}
+		}
+		if len(payload) != len(want) {
+			t.Errorf("payload = %v, want only %v", payload, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook was not called")
+	}
+}
+
+func TestWebhookNotifierStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := webhookNotifier{url: srv.URL}.Notify(context.Background(), Notification{Kind: NotifyConflict})
+	if err == nil {
+		t.Error("Notify() to a failing webhook succeeded, want an error")
+	}
+}
+
+// recordingNotifier passes on what it is asked to deliver.
+type recordingNotifier chan Notification
+
+func (r recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	r <- n
+	return nil
+}
+
+func TestNotifiersRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	notifiers := NewNotifiers(&Config{Notify: NotifyConfig{Interval: "10m"}}, clock)
+	delivered := make(recordingNotifier, 10)
+	notifiers.backends = []Notifier{delivered}
+
+	type call struct {
+		advance time.Duration
+		kind    string
+		repo    string
+		sent    bool
+	}
+	calls := []call{
+		{kind: NotifyPushFailed, repo: "a", sent: true},
+		{kind: NotifyPushFailed, repo: "a", sent: false},
+		{kind: NotifyPushFailed, repo: "b", sent: true},
+		{kind: NotifyConflict, repo: "a", sent: true},
+		{advance: 9 * time.Minute, kind: NotifyPushFailed, repo: "a", sent: false},
+		{advance: time.Minute, kind: NotifyPushFailed, repo: "a", sent: true},
+		{kind: NotifyPushFailed, repo: "a", sent: false},
+	}
+
+	for i, c := range calls {
+		clock.Advance(c.advance)
+		notifiers.Notify(c.kind, c.repo, errors.New("failed"))
+
+		select {
+		case n := <-delivered:
+			if !c.sent {
+				t.Fatalf("call %d: %s for %s was sent, want it rate limited", i, c.kind, c.repo)
+			}
+			if n.Kind != c.kind || n.Repo != c.repo || !n.Time.Equal(clock.Now()) {
+				t.Errorf("call %d: delivered %+v, want %s for %s at %v", i, n, c.kind, c.repo, clock.Now())
+			}
+		case <-time.After(100 * time.Millisecond):
+			if c.sent {
+				t.Fatalf("call %d: %s for %s was not sent", i, c.kind, c.repo)
+			}
+		}
+	}
+}
+
+func TestNotifiersSetConfigKeepsRateLimits(t *testing.T) {
+	clock := newFakeClock()
+	notifiers := NewNotifiers(&Config{}, clock)
+	delivered := make(recordingNotifier, 10)
+	notifiers.backends = []Notifier{delivered}
+
+	notifiers.Notify(NotifyPullFailed, "a", errors.New("failed"))
+	notifiers.SetConfig(&Config{Notify: NotifyConfig{Interval: "1h"}})
+	notifiers.backends = []Notifier{delivered}
+
+	clock.Advance(30 * time.Minute)
+	notifiers.Notify(NotifyPullFailed, "a", errors.New("failed"))
+	clock.Advance(30 * time.Minute)
+	notifiers.Notify(NotifyPullFailed, "a", errors.New("failed"))
+
+	got := make([]time.Time, 0)
+	timeout := time.After(time.Second)
+	for len(got) < 2 {
+		select {
+		case n := <-delivered:
+			got = append(got, n.Time)
+		case <-timeout:
+			t.Fatalf("delivered %v, want two notifications", got)
+		}
+	}
+
+	start := clock.Now().Add(-time.Hour)
+	want := []time.Time{start, clock.Now()}
+	slices.SortFunc(got, time.Time.Compare)
+	if !slices.EqualFunc(got, want, time.Time.Equal) {
+		t.Errorf("delivered at %v, want %v", got, want)
+	}
+}
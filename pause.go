@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// resumeCheckInterval is how often the bot looks for timed pauses that have
+// run out.
+const resumeCheckInterval = 30 * time.Second
+
+// watchState picks up pause and resume requests written to the state file by
+// the command line, and ends timed pauses.
+func (b *Bot) watchState() {
+	if err := os.MkdirAll(filepath.Dir(b.statePath), 0700); err != nil {
+		slog.Error("error creating state directory", "file", b.statePath, "err", err)
+	}
+	if err := b.stateWatcher.Add(filepath.Dir(b.statePath)); err != nil {
+		slog.Error("error watching state", "file", b.statePath, "err", err)
+	}
+
+	// Pick up anything resumed while the bot was down
+	b.flushResumed()
+
+	tick, stop := b.clock.After(resumeCheckInterval)
+	defer func() { stop() }()
+
+	for {
+		select {
+
+		case event, ok := <-b.stateWatcher.Events:
+			if !ok {
+				return
+			}
+			if event.Name == b.statePath {
+				b.flushResumed()
+			}
+
+		case <-tick:
+			b.expirePauses()
+			tick, stop = b.clock.After(resumeCheckInterval)
+
+		case err, ok := <-b.stateWatcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("error from the state watcher", "file", b.statePath, "err", err)
+		}
+	}
+}
+
+// hold reports whether auto-commit is paused for repo. A held change is
+// recorded so the repository is committed when it is resumed.
+func (b *Bot) hold(repo, file string) bool {
+	state, err := LoadState(b.statePath)
+	if err != nil {
+		slog.Error("error loading state", "repo", repo, "err", err)
+		return false
+	}
+
+	if !state.IsPaused(repo, b.clock.Now()) {
+		return false
+	}
+
+	slog.Info("paused, holding change", "repo", repo, "file", file)
+	if state.Repos[repo] != nil && state.Repos[repo].Pending {
+		return true
+	}
+	b.notifier.Notify(NotifyPaused, repo, errors.New("auto-commit is paused, holding changes until resumed"))
+
+	_, err = UpdateState(b.statePath, func(s *State) error {
+		s.Repo(repo).Pending = true
+		return nil
+	})
+	if err != nil {
+		slog.Error("error saving state", "repo", repo, "err", err)
+	}
+
+	return true
+}
+
+// expirePauses ends timed pauses whose resume time has passed.
+func (b *Bot) expirePauses() {
+	// Read first so an idle bot does not rewrite the file every tick
+	state, err := LoadState(b.statePath)
+	if err != nil {
+		slog.Error("error loading state", "file", b.statePath, "err", err)
+		return
+	}
+	if !state.Expire(b.clock.Now()) {
+		return
+	}
+
+	_, err = UpdateState(b.statePath, func(s *State) error {
+		s.Expire(b.clock.Now())
+		return nil
+	})
+	if err != nil {
+		slog.Error("error saving state", "file", b.statePath, "err", err)
+	}
+	// The write is picked up by watchState, which flushes the resumed repos
+}
+
+// flushResumed queues a commit for every repository that has changes held
+// back by a pause that has since ended. All the changes made while paused
+// go out together in that one commit.
+func (b *Bot) flushResumed() {
+	state, err := LoadState(b.statePath)
+	if err != nil {
+		slog.Error("error loading state", "file", b.statePath, "err", err)
+		return
+	}
+	if len(resumedRepos(state, b.clock.Now())) == 0 {
+		return
+	}
+
+	var resumed []string
+	_, err = UpdateState(b.statePath, func(s *State) error {
+		resumed = resumedRepos(s, b.clock.Now())
+		for _, path := range resumed {
+			s.Repos[path].Pending = false
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("error saving state", "file", b.statePath, "err", err)
+		return
+	}
+
+	for _, path := range resumed {
+		slog.Info("resumed, committing held changes", "repo", path)
+		b.resumed <- path
+	}
+}
+
+func resumedRepos(state *State, now time.Time) []string {
+	resumed := make([]string, 0)
+	for path, repo := range state.Repos {
+		if repo.Pending && !state.IsPaused(path, now) {
+			resumed = append(resumed, path)
+		}
+	}
+
+	return resumed
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets an editor finish writing the config before it is read,
+// and folds the burst of events a single save produces into one reload.
+const reloadDelay = 250 * time.Millisecond
+
+// watchConfig reloads the config when its file changes or the process
+// receives SIGHUP. Every reload runs on this goroutine, so two can never
+// apply at once.
+func (b *Bot) watchConfig() {
+	path, err := filepath.Abs(b.config().Path())
+	if err != nil {
+		slog.Error("error resolving config path", "file", b.config().Path(), "err", err)
+		return
+	}
+
+	// Editors often replace the file rather than write to it, which drops a
+	// watch on the file itself, so watch the directory instead.
+	if err := b.configWatcher.Add(filepath.Dir(path)); err != nil {
+		slog.Error("error watching config", "file", path, "err", err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// The timer only signals, the reload itself happens in the loop
+	changed := make(chan struct{}, 1)
+	var timer *time.Timer
+	for {
+		select {
+
+		case event, ok := <-b.configWatcher.Events:
+			if !ok {
+				return
+			}
+			if event.Name != path || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(reloadDelay, func() {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			})
+
+		case <-changed:
+			b.reload("config file changed")
+
+		case <-hup:
+			b.reload("SIGHUP")
+
+		case err, ok := <-b.configWatcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("error from the config watcher", "file", path, "err", err)
+		}
+	}
+}
+
+// reload reads the config file again and applies the difference. A config
+// that fails to load is rejected and the bot carries on with the old one.
+func (b *Bot) reload(reason string) {
+	prev := b.config()
+	slog.Info("reloading config", "file", prev.Path(), "reason", reason)
+
+	next, err := NewConfig(prev.Path())
+	if err != nil {
+		slog.Error("rejected new config, keeping the old one", "file", prev.Path(), "err", err)
+		return
+	}
+
+	changed := diffConfig(prev, next)
+	if len(changed) == 0 {
+		slog.Info("config unchanged", "file", prev.Path())
+		return
+	}
+
+	if err := b.applyConfig(next, changed); err != nil {
+		slog.Error("rejected new config, keeping the old one", "file", prev.Path(), "err", err)
+		return
+	}
+
+	slog.Info("config reloaded", "file", prev.Path(), "changed", changed)
+}
+
+// applyConfig switches the bot over to next. Repositories are opened per
+// change with the current config, so identity, credentials and filters take
+// effect on the next event; roots need their watches added or removed.
+func (b *Bot) applyConfig(next *Config, changed []string) error {
+	b.mu.RLock()
+	oldRepos := b.repos
+	b.mu.RUnlock()
+
+	finder := NewFinder(next)
+	repos := oldRepos
+	if slices.Contains(changed, "RootDir") || slices.Contains(changed, "RootDirs") {
+		found, err := finder.Find()
+		if err != nil {
+			return fmt.Errorf("error finding repos %v", err)
+		}
+		repos = found
+	}
+
+	if slices.Contains(changed, "LogLevel") || slices.Contains(changed, "LogFormat") {
+		if err := setupLogging(os.Stderr, next.LogLevel, next.LogFormat); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	b.conf = next
+	b.finder = finder
+	b.repos = repos
+	b.mu.Unlock()
+
+	b.watcher.SetConfig(next)
+	b.notifier.SetConfig(next)
+
+	added, removed := diffPaths(oldRepos, repos)
+	for _, path := range removed {
+		if err := b.watcher.Remove(path); err != nil {
+			slog.Error("error removing watch", "repo", path, "err", err)
+		}
+	}
+
+	if slices.Contains(changed, "Repos") || slices.Contains(changed, "Vars") {
+		for _, path := range repos {
+			b.deploy(path)
+			b.mirror(path)
+		}
+	}
+	b.updateMirrorWatches()
+
+	if len(added) > 0 {
+		b.reconcileAll(added)
+		for _, err := range b.watcher.AddWatches(added) {
+			slog.Error("error adding watch", "err", err)
+		}
+	}
+
+	return nil
+}
+
+// diffPaths returns the paths only in next and the paths only in prev.
+func diffPaths(prev, next []string) (added, removed []string) {
+	seen := make(map[string]bool, len(prev))
+	for _, path := range prev {
+		seen[path] = true
+	}
+
+	for _, path := range next {
+		if !seen[path] {
+			added = append(added, path)
+		}
+		delete(seen, path)
+	}
+
+	for _, path := range prev {
+		if seen[path] {
+			removed = append(removed, path)
+		}
+	}
+
+	return added, removed
+}
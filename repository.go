@@ -1,234 +1,453 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
 	"time"
+)
 
-	"github.com/libgit2/git2go"
+const (
+	// maxPushAttempts bounds how often a rejected push is retried
+	maxPushAttempts = 5
+	// pushBackoff is the most a retry waits per attempt, picked at random
+	pushBackoff = time.Second
 )
 
+// randomBackoff is how long to wait before retrying a rejected push. Both
+// machines may be retrying, so the wait is random to keep them out of
+// lockstep.
+func randomBackoff(attempt int) time.Duration {
+	return time.Duration(attempt) * time.Duration(rand.Int64N(int64(pushBackoff)))
+}
+
+// commitMessage is the message of every auto-commit, it is how the bot tells
+// its own commits apart from the user's.
+const commitMessage = "Committed by the Gist Bot"
+
+// Repository is a gist checkout. It decides what to commit and how to sync
+// with origin, and leaves the git work to the configured backend.
 type Repository struct {
 	conf *Config
-	repo *git.Repository
+	git  GitRepo
+	path string
+	log  *slog.Logger
+	// held lists the files Add left unstaged, which Commit leaves out
+	held []string
+
+	// clock and backoff time the retries of a rejected push
+	clock   Clock
+	backoff func(attempt int) time.Duration
+	// notify reports what the user should know about, like held files
+	notify func(kind string, err error)
 }
 
 func NewRepository(conf *Config, path string) (*Repository, error) {
-	repo, err := git.OpenRepository(path)
+	repo, err := conf.backend().Open(conf, path)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create repository: %v", err)
+		return nil, err
 	}
 
-	return &Repository{conf: conf, repo: repo}, nil
+	return &Repository{
+		conf:    conf,
+		git:     repo,
+		path:    path,
+		log:     slog.With("repo", path),
+		clock:   realClock{},
+		backoff: randomBackoff,
+		notify:  func(string, error) {},
+	}, nil
 }
 
-func (r *Repository) Add() (*git.Tree, error) {
-	index, err := r.repo.Index()
-	if err != nil {
-		return nil, fmt.Errorf("error getting index: %v", err)
+// Add stages every change, encrypting the files that match Encrypt first so
+// only their ciphertext is staged. Files the guardrails hold back, like large
+// or binary files, are left unstaged with a warning.
+func (r *Repository) Add() error {
+	if err := r.seal(); err != nil {
+		return err
 	}
 
-	if err = index.AddAll([]string{}, git.IndexAddDefault, nil); err != nil {
-		return nil, fmt.Errorf("error adding all files to the index")
+	// Checked before staging, so a held file never lands in .git/objects
+	if err := r.guard(); err != nil {
+		return err
 	}
 
-	treeId, err := index.WriteTreeTo(r.repo)
-	if err != nil {
-		return nil, fmt.Errorf("error creating tree: %v", err)
+	if err := r.git.AddAll(r.held); err != nil {
+		return err
 	}
 
-	if err = index.Write(); err != nil {
-		return nil, fmt.Errorf("error writing index: %v", err)
+	// A held file that was staged some other way stays out too
+	for _, name := range r.held {
+		if err := r.git.Unstage(name); err != nil {
+			return err
+		}
 	}
 
-	tree, err := r.repo.LookupTree(treeId)
+	return nil
+}
+
+// guard picks the files that break the guardrails, which Add leaves
+// unstaged, and reports them.
+func (r *Repository) guard() error {
+	files, err := r.git.DirtyFiles()
 	if err != nil {
-		return nil, fmt.Errorf("error looking up tree: %v", err)
+		return err
 	}
 
-	return tree, nil
+	held, err := r.conf.guardrails(r.path).check(r.path, files)
+	if err != nil {
+		return fmt.Errorf("error checking files against the guardrails: %v", err)
+	}
+
+	r.held = r.held[:0]
+	reasons := make([]string, 0, len(held))
+	for _, file := range held {
+		r.log.Warn("file left unstaged", "file", file.Name, "size", file.Size, "reason", file.Reason)
+		r.held = append(r.held, file.Name)
+		reasons = append(reasons, fmt.Sprintf("%s (%s)", file.Name, file.Reason))
+	}
+	if len(held) > 0 {
+		r.notify(NotifyFilesHeld, fmt.Errorf("left unstaged: %s", strings.Join(reasons, ", ")))
+	}
+
+	return nil
 }
 
-func (r *Repository) Commit(tree *git.Tree) error {
-	var sig *git.Signature = &git.Signature{
-		Name:  r.conf.Name,
-		Email: r.conf.Email,
-		When:  time.Now(),
+// seal encrypts the files that match Encrypt and takes any plaintext that
+// was committed before out of the index.
+func (r *Repository) seal() error {
+	if len(r.conf.Encrypt) == 0 {
+		return nil
 	}
-	var message string = "Committed by the Gist Bot"
-	head, _ := r.head()
 
-	commitTarget, err := r.repo.LookupCommit(head.Target())
+	sealed, err := r.conf.sealFiles(r.path)
 	if err != nil {
-		return fmt.Errorf("error looking up commit on local head: %v", err)
+		return err
 	}
 
-	commitId, err := r.repo.CreateCommit("refs/heads/master", sig, sig, message, tree, commitTarget)
-	if err != nil {
-		return fmt.Errorf("error creating commit: %v", err)
+	for _, name := range sealed {
+		if err = r.git.Untrack(name); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("commit: %s", commitId)
 	return nil
 }
 
-func (r *Repository) Push() error {
-	remote, err := r.repo.Remotes.Lookup("origin")
+// Commit commits the staged changes, running the pre-commit and post-commit
+// hooks around it.
+func (r *Repository) Commit() error {
+	hooks := r.conf.hooks(r.path)
+
+	files, err := r.git.DirtyFiles()
 	if err != nil {
-		return fmt.Errorf("error looking up remote origin: %v", err)
+		return err
+	}
+	files = slices.DeleteFunc(files, func(file string) bool { return slices.Contains(r.held, file) })
+	if len(files) == 0 {
+		r.log.Debug("nothing to commit")
+		return nil
 	}
 
-	if err = remote.Push([]string{"refs/heads/master"}, r.pushOptions()); err != nil {
-		return fmt.Errorf("error pushing to remote: %v", err)
+	if err = r.runHook("pre-commit", hooks.PreCommit, files, ""); err != nil {
+		return fmt.Errorf("commit blocked: %w", err)
+	}
+
+	commitId, err := r.git.Commit(r.message(commitMessage), r.author(), r.committer())
+	if err != nil {
+		return err
+	}
+
+	r.log.Info("commit created", "commit", commitId)
+
+	if err = r.runHook("post-commit", hooks.PostCommit, files, commitId); err != nil {
+		r.log.Warn("error running hook", "err", err)
 	}
 
 	return nil
 }
 
-func (r *Repository) Pull(ch chan error) {
-	if err := r.fetch(); err != nil {
-		ch <- err
-		return
+// Push pushes master and then runs the post-push hook with the files the
+// push brought to origin. A push rejected because another machine pushed
+// first is retried after integrating its changes. Push reports whether it
+// pulled such changes in, so the caller can deploy them.
+func (r *Repository) Push() (bool, error) {
+	hooks := r.conf.hooks(r.path)
+
+	pulled := false
+	var files []string
+	for attempt := 1; ; attempt++ {
+		if hooks.PostPush != "" {
+			var err error
+			if files, err = r.git.ChangedFiles("refs/remotes/origin/master", "refs/heads/master"); err != nil {
+				r.log.Warn("error listing pushed files", "err", err)
+			}
+		}
+
+		err := r.git.Push()
+		if err == nil {
+			break
+		}
+
+		var rejected *RejectedError
+		if !errors.As(err, &rejected) || attempt == maxPushAttempts {
+			return pulled, err
+		}
+
+		backoff := r.backoff(attempt)
+		r.log.Info("push rejected, integrating remote changes", "attempt", attempt, "backoff", backoff)
+		wait, _ := r.clock.After(backoff)
+		<-wait
+
+		moved, err := r.integrate()
+		pulled = pulled || moved
+		if err != nil {
+			return pulled, fmt.Errorf("error integrating remote changes after rejected push: %w", err)
+		}
 	}
 
-	if err := r.merge(); err != nil {
-		ch <- err
-		return
+	if hooks.PostPush == "" {
+		return pulled, nil
 	}
 
-	ch <- nil
-}
+	commitId, err := r.git.RevParse("refs/heads/master")
+	if err != nil {
+		r.log.Warn("error resolving pushed commit", "err", err)
+	}
+	if err = r.runHook("post-push", hooks.PostPush, files, commitId); err != nil {
+		r.log.Warn("error running hook", "err", err)
+	}
 
-func (r *Repository) credentialsCallback(url string, username string, allowedTypes git.CredType) (git.ErrorCode, *git.Cred) {
-	ret, cred := git.NewCredSshKey("git", r.conf.PublicKey, r.conf.PrivateKey, "")
-	return git.ErrorCode(ret), &cred
+	return pulled, nil
 }
 
-func (r *Repository) certificateCheckCallback(cert *git.Certificate, valid bool, hostname string) git.ErrorCode {
-	return 0
+// Reconciliation is what Reconcile found left over from while the bot was
+// not running.
+type Reconciliation struct {
+	// Committed lists the files that had uncommitted changes
+	Committed []string
+	// Unpushed counts the commits on master that origin does not have yet
+	Unpushed int
 }
 
-func (r *Repository) fetchOptions() *git.FetchOptions {
-	fo := git.FetchOptions{
-		RemoteCallbacks: git.RemoteCallbacks{
-			CredentialsCallback:      r.credentialsCallback,
-			CertificateCheckCallback: r.certificateCheckCallback,
-		},
-	}
-	return &fo
-}
+// Reconcile catches the repository up on changes made while the bot was not
+// running: uncommitted edits are committed and origin is pulled. Local
+// commits that still need pushing are counted and left to the caller to
+// push.
+func (r *Repository) Reconcile() (*Reconciliation, error) {
+	rec := &Reconciliation{}
 
-func (r *Repository) pushOptions() *git.PushOptions {
-	po := git.PushOptions{
-		RemoteCallbacks: git.RemoteCallbacks{
-			CredentialsCallback:      r.credentialsCallback,
-			CertificateCheckCallback: r.certificateCheckCallback,
-		},
+	// Commit local edits first so updating the checkout cannot lose them
+	committed, err := r.commitDirty()
+	if err != nil {
+		r.log.Error("error committing local changes", "err", err)
+		return rec, err
 	}
-	return &po
-}
+	rec.Committed = committed
 
-func (r *Repository) signature() *git.Signature {
-	return &git.Signature{
-		Name:  r.conf.Name,
-		Email: r.conf.Email,
-		When:  time.Now(),
+	if _, err = r.integrate(); err != nil {
+		return rec, err
 	}
-}
 
-func (r *Repository) origin() (*git.Remote, error) {
-	remote, err := r.repo.Remotes.Lookup("origin")
+	unpushed, err := r.git.LocalCommits()
 	if err != nil {
-		fmt.Errorf("error looking up origin: %v", err)
-		return nil, err
+		return rec, fmt.Errorf("error listing unpushed commits: %v", err)
 	}
+	rec.Unpushed = len(unpushed)
 
-	return remote, nil
+	return rec, nil
 }
 
-func (r *Repository) masterRemote() (*git.Reference, error) {
-	master, err := r.repo.References.Lookup("refs/remotes/origin/master") // remote master..
+// integrate fetches origin and brings master up to date with it, using the
+// pull strategy when they have diverged. It reports whether master moved.
+func (r *Repository) integrate() (bool, error) {
+	before, err := r.git.RevParse("refs/heads/master")
 	if err != nil {
-		fmt.Errorf("error looking up master branch: %v", err)
-		return nil, err
+		return false, err
 	}
 
-	return master, nil
-}
+	if err = r.git.Fetch(); err != nil {
+		r.log.Error("error fetching", "err", err)
+		return false, err
+	}
 
-func (r *Repository) fastForward(oid *git.Oid) error {
-	head, _ := r.head()
+	if err = r.merge(); err != nil {
+		r.log.Error("error merging", "err", err)
+		return false, err
+	}
 
-	// Lookup the git tree object for the given ref
-	remoteTree, err := r.repo.LookupTree(oid)
+	after, err := r.git.RevParse("refs/heads/master")
 	if err != nil {
-		return fmt.Errorf("error lookup tree HEAD: %v", err)
+		return false, err
 	}
+	moved := after != before
 
-	// Honestly, don't fully understand this one
-	if err := r.repo.CheckoutTree(remoteTree, nil); err != nil {
-		return err
+	if len(r.conf.Encrypt) > 0 {
+		if err = r.conf.openFiles(r.path); err != nil {
+			r.log.Error("error decrypting files", "err", err)
+			return moved, err
+		}
 	}
 
-	// lookup local master branch
-	branchRef, err := r.repo.References.Lookup("refs/heads/master")
-	if err != nil {
-		return fmt.Errorf("unable to lookup refs/heads/master: %v", err)
+	return moved, nil
+}
+
+// author is the person whose edits the bot commits.
+func (r *Repository) author() *Signature {
+	return &Signature{
+		Name:  r.conf.Name,
+		Email: r.conf.Email,
+		When:  time.Now(),
 	}
+}
 
-	// Point branch to the object (don't fully understand this one too)
-	branchRef.SetTarget(oid, "")
-	if _, err := head.SetTarget(oid, ""); err != nil {
-		return err
+// committer is the bot itself, which may have an identity of its own.
+func (r *Repository) committer() *Signature {
+	sig := r.author()
+	if r.conf.CommitterName != "" {
+		sig.Name = r.conf.CommitterName
+	}
+	if r.conf.CommitterEmail != "" {
+		sig.Email = r.conf.CommitterEmail
 	}
 
-	return nil
+	return sig
 }
 
-func (r *Repository) fetch() error {
-	remote, err := r.origin()
-	if err != nil {
-		return err
+// message adds the configured Gistbot-Host and Gistbot-Version trailers to
+// a commit message.
+func (r *Repository) message(msg string) string {
+	trailers := make([]string, 0, 2)
+	if r.conf.HostTrailer {
+		host, err := os.Hostname()
+		if err != nil {
+			r.log.Warn("error getting hostname for trailer", "err", err)
+		} else {
+			trailers = append(trailers, hostTrailer+host)
+		}
 	}
-
-	if err = remote.Fetch([]string{}, r.fetchOptions(), ""); err != nil {
-		return err
+	if r.conf.VersionTrailer {
+		trailers = append(trailers, "Gistbot-Version: "+version)
+	}
+	if len(trailers) == 0 {
+		return msg
 	}
 
-	return nil
+	return strings.TrimRight(msg, "\n") + "\n\n" + strings.Join(trailers, "\n") + "\n"
 }
 
 func (r *Repository) merge() error {
-	masterRemote, err := r.masterRemote()
+	analysis, err := r.git.Analyze()
 	if err != nil {
 		return err
 	}
 
-	target := masterRemote.Target()
-	annotatedCommit, _ := r.repo.AnnotatedCommitFromRef(masterRemote)
-	mergeHeads := []*git.AnnotatedCommit{annotatedCommit}
-	analysis, _, _ := r.repo.MergeAnalysis(mergeHeads)
-
-	if analysis&git.MergeAnalysisUpToDate != 0 {
-		log.Println("Everything up to date")
+	switch analysis {
+	case UpToDate:
+		r.log.Debug("everything up to date")
 
-		return nil
-	} else if analysis&git.MergeAnalysisFastForward != 0 {
-		// Fast forward
+	case Behind:
+		commitId, err := r.git.FastForward()
+		if err != nil {
+			return err
+		}
+		r.log.Info("fast-forwarded", "commit", commitId)
+
+	case Diverged:
+		// Local commits are on top of an older origin/master
+		if r.conf.pullStrategy() == StrategyRebase {
+			commitId, err := r.git.Rebase(r.committer())
+			if err != nil {
+				return err
+			}
+			r.log.Info("rebased onto origin/master", "commit", commitId)
+			return nil
+		}
 
-		if err = r.fastForward(target); err != nil {
+		commitId, err := r.git.Merge(r.message("Merge origin/master"), r.author(), r.committer())
+		if err != nil {
 			return err
 		}
+		r.log.Info("merged origin/master", "commit", commitId)
 	}
 
 	return nil
 }
 
-func (r *Repository) head() (*git.Reference, error) {
-	head, err := r.repo.Head()
+// commitDirty commits uncommitted changes in the working tree through the
+// normal auto-commit path, and returns the files it committed.
+func (r *Repository) commitDirty() ([]string, error) {
+	// Plaintext is never dirty as far as git knows, seal it to find out
+	if err := r.seal(); err != nil {
+		return nil, err
+	}
+
+	dirty, err := r.git.DirtyFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(dirty) == 0 {
+		return nil, nil
+	}
+
+	r.log.Info("committing local changes", "files", dirty)
+	if err = r.Add(); err != nil {
+		return nil, fmt.Errorf("error git add: %v", err)
+	}
+	dirty = slices.DeleteFunc(dirty, func(file string) bool { return slices.Contains(r.held, file) })
+
+	return dirty, r.Commit()
+}
+
+// SyncStatus is how a repository stands against origin, as of the last
+// fetch.
+type SyncStatus struct {
+	// Ahead counts the commits origin does not have yet
+	Ahead int
+	// Behind counts the commits on origin not pulled yet
+	Behind int
+	// Dirty lists the files with changes not committed yet
+	Dirty []string
+	// LastCommit is when the tip of master was committed
+	LastCommit time.Time
+}
+
+// Status reports how the repository stands against origin, without
+// fetching.
+func (r *Repository) Status() (*SyncStatus, error) {
+	ahead, behind, err := r.git.AheadBehind()
+	if err != nil {
+		return nil, err
+	}
+
+	dirty, err := r.git.DirtyFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := r.git.CommitObject("refs/heads/master")
+	if err != nil {
+		return nil, err
+	}
+	lastCommit, err := commitTime(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyncStatus{Ahead: ahead, Behind: behind, Dirty: dirty, LastCommit: lastCommit}, nil
+}
+
+// Verify checks the signature on the tip of master against the configured
+// key, e.g. "good ssh signature" or "unsigned".
+func (r *Repository) Verify() (string, error) {
+	raw, err := r.git.CommitObject("refs/heads/master")
 	if err != nil {
-		return nil, fmt.Errorf("error getting HEAD: %v", err)
+		return "", err
 	}
 
-	return head, nil
+	return r.conf.verifyCommit(raw)
 }
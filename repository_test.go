@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openRepository(t *testing.T, conf *Config, path string) *Repository {
+	t.Helper()
+
+	repo, err := NewRepository(conf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+// commitFile saves a file in repo and commits it the way the bot does.
+func commitFile(t *testing.T, repo *Repository, name, content string) {
+	t.Helper()
+
+	writeFile(t, filepath.Join(repo.path, name), content)
+	if err := repo.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushIntegratesRejectedPush(t *testing.T) {
+	for _, strategy := range []string{StrategyMerge, StrategyRebase} {
+		t.Run(strategy, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, backend string) {
+				remote := newTestRemote(t)
+				root := t.TempDir()
+				conf := testConfig(t, root, backend)
+				conf.PullStrategy = strategy
+
+				// Two machines with clones of the same gist
+				a := openRepository(t, conf, remote.clone(filepath.Join(root, "a")))
+				b := openRepository(t, conf, remote.clone(filepath.Join(root, "b")))
+
+				commitFile(t, a, "a.txt", "from a\n")
+				if pulled, err := a.Push(); err != nil || pulled {
+					t.Fatalf("Push() = %t, %v, want a plain push", pulled, err)
+				}
+				first := remote.head()
+
+				attempts := make([]int, 0)
+				b.clock = newFakeClock()
+				b.backoff = func(attempt int) time.Duration {
+					attempts = append(attempts, attempt)
+					return 0
+				}
+				commitFile(t, b, "b.txt", "from b\n")
+				pulled, err := b.Push()
+				if err != nil {
+					t.Fatalf("Push() after a rejection: %v", err)
+				}
+				if !pulled {
+					t.Error("Push() did not report pulling in the changes from a")
+				}
+				if !slices.Equal(attempts, []int{1}) {
+					t.Errorf("backoff attempts = %v, want [1]", attempts)
+				}
+
+				if _, err := a.Reconcile(); err != nil {
+					t.Fatal(err)
+				}
+				head := remote.head()
+				for name, path := range map[string]string{"a": a.path, "b": b.path} {
+					if got := runGit(t, path, "rev-parse", "master"); got != head {
+						t.Errorf("%s master = %s, want the remote head %s", name, got, head)
+					}
+					for _, file := range []string{"a.txt", "b.txt"} {
+						if _, err := gitOutput(path, "cat-file", "-e", "master:"+file); err != nil {
+							t.Errorf("%s master is missing %s", name, file)
+						}
+					}
+				}
+
+				parents := strings.Fields(runGit(t, remote.path, "log", "-1", "--format=%P", "master"))
+				switch strategy {
+				case StrategyMerge:
+					if len(parents) != 2 || !slices.Contains(parents, first) {
+						t.Errorf("remote head parents = %v, want a merge with %s", parents, first)
+					}
+				case StrategyRebase:
+					if !slices.Equal(parents, []string{first}) {
+						t.Errorf("remote head parents = %v, want b rebased onto %s", parents, first)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestReconcileCommitsDirtyTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// local commits a file before the tree is made dirty, so master
+		// has diverged from origin
+		local bool
+	}{
+		{name: "edits only", strategy: StrategyMerge},
+		{name: "diverged merge", strategy: StrategyMerge, local: true},
+		{name: "diverged rebase", strategy: StrategyRebase, local: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, backend string) {
+				remote := newTestRemote(t)
+				root := t.TempDir()
+				conf := testConfig(t, root, backend)
+				conf.PullStrategy = tt.strategy
+				repo := openRepository(t, conf, remote.clone(filepath.Join(root, "notes")))
+
+				// The dirty files are committed on top of master, so it
+				// diverges from origin either way
+				unpushed := 1
+				if tt.strategy == StrategyMerge {
+					unpushed++
+				}
+				if tt.local {
+					commitFile(t, repo, "local.txt", "committed while offline\n")
+					unpushed++
+				}
+				// Edits made while the bot was not running
+				writeFile(t, filepath.Join(repo.path, "README.md"), "# gist\nedited offline\n")
+				writeFile(t, filepath.Join(repo.path, "new.txt"), "not yet tracked\n")
+
+				remoteHead := remote.commit("remote.txt", "from another machine\n")
+
+				rec, err := repo.Reconcile()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				committed := slices.Sorted(slices.Values(rec.Committed))
+				if !slices.Equal(committed, []string{"README.md", "new.txt"}) {
+					t.Errorf("Committed = %v, want README.md and new.txt", rec.Committed)
+				}
+				if rec.Unpushed != unpushed {
+					t.Errorf("Unpushed = %d, want %d", rec.Unpushed, unpushed)
+				}
+				if _, err := gitOutput(repo.path, "merge-base", "--is-ancestor", remoteHead, "master"); err != nil {
+					t.Errorf("master does not contain the remote head %s", remoteHead)
+				}
+
+				want := map[string]string{
+					"README.md":  "# gist\nedited offline\n",
+					"new.txt":    "not yet tracked\n",
+					"remote.txt": "from another machine\n",
+				}
+				if tt.local {
+					want["local.txt"] = "committed while offline\n"
+				}
+				for name, content := range want {
+					if got := readFile(t, filepath.Join(repo.path, name)); got != content {
+						t.Errorf("%s = %q, want %q", name, got, content)
+					}
+				}
+				if dirty := runGit(t, repo.path, "status", "--porcelain"); dirty != "" {
+					t.Errorf("working tree is dirty after reconciling:\n%s", dirty)
+				}
+			})
+		})
+	}
+}
+
+func TestReconcileConflictKeepsLocalEdit(t *testing.T) {
+	for _, strategy := range []string{StrategyMerge, StrategyRebase} {
+		t.Run(strategy, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, backend string) {
+				remote := newTestRemote(t)
+				root := t.TempDir()
+				conf := testConfig(t, root, backend)
+				conf.PullStrategy = strategy
+				repo := openRepository(t, conf, remote.clone(filepath.Join(root, "notes")))
+
+				writeFile(t, filepath.Join(repo.path, "README.md"), "# gist\nedited here\n")
+				remote.commit("README.md", "# gist\nedited there\n")
+
+				_, err := repo.Reconcile()
+				var conflict *ConflictError
+				if !errors.As(err, &conflict) {
+					t.Fatalf("Reconcile() error = %v, want a *ConflictError", err)
+				}
+
+				if got := readFile(t, filepath.Join(repo.path, "README.md")); got != "# gist\nedited here\n" {
+					t.Errorf("README.md = %q, the local edit was lost", got)
+				}
+				if got := runGit(t, repo.path, "show", "master:README.md"); got != "# gist\nedited here" {
+					t.Errorf("master:README.md = %q, want the local edit committed", got)
+				}
+			})
+		})
+	}
+}
+
+func TestPushDeclinedIsNotRetried(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		writeFile(t, filepath.Join(remote.path, "hooks", "pre-receive"), "#!/bin/sh\necho 'no pushes today' >&2\nexit 1\n")
+		if err := os.Chmod(filepath.Join(remote.path, "hooks", "pre-receive"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		root := t.TempDir()
+		repo := openRepository(t, testConfig(t, root, backend), remote.clone(filepath.Join(root, "notes")))
+		repo.backoff = func(attempt int) time.Duration {
+			t.Errorf("push retried after a hook declined it, attempt %d", attempt)
+			return 0
+		}
+
+		commitFile(t, repo, "todo.txt", "buy milk\n")
+		_, err := repo.Push()
+		var rejected *RejectedError
+		if err == nil || errors.As(err, &rejected) {
+			t.Errorf("Push() error = %v, want a failure that is not a *RejectedError", err)
+		}
+	})
+}
+
+func TestAddNeverHashesHeldFiles(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend string) {
+		remote := newTestRemote(t)
+		root := t.TempDir()
+		conf := testConfig(t, root, backend)
+		conf.MaxFileSize = "1KB"
+		repo := openRepository(t, conf, remote.clone(filepath.Join(root, "notes")))
+
+		held := map[string]string{
+			"photo.png":      "\x89PNG\r\n\x1a\n\x00\x00",
+			"logs/debug.log": strings.Repeat("debug\n", 1024),
+		}
+		for name, content := range held {
+			writeFile(t, filepath.Join(repo.path, name), content)
+		}
+		commitFile(t, repo, "todo.txt", "buy milk\n")
+
+		if got := runGit(t, repo.path, "show", "master:todo.txt"); got != "buy milk" {
+			t.Errorf("master:todo.txt = %q, want the small text file committed", got)
+		}
+		for name := range held {
+			id := runGit(t, repo.path, "hash-object", name)
+			if _, err := gitOutput(repo.path, "cat-file", "-e", id); err == nil {
+				t.Errorf("%s was written to the object store", name)
+			}
+			if tracked := runGit(t, repo.path, "ls-files", "--stage", "--", name); tracked != "" {
+				t.Errorf("%s was staged: %s", name, tracked)
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Commit and push policies. Saves are committed straight away or gathered up
+// and committed every CommitInterval. Commits are pushed straight away, every
+// PushInterval, at the PushAt times of day, or only when the bot shuts down.
+const (
+	PolicySave     = "save"
+	PolicyInterval = "interval"
+	PolicyAt       = "at"
+	PolicyShutdown = "shutdown"
+)
+
+// scheduler tracks the repositories waiting on the commit and push policies.
+// It is only touched by the goroutine listening for changes.
+type scheduler struct {
+	uncommitted map[string]bool
+	unpushed    map[string]bool
+	lastCommit  time.Time
+	lastPush    time.Time
+}
+
+func newScheduler(now time.Time) *scheduler {
+	return &scheduler{
+		uncommitted: make(map[string]bool),
+		unpushed:    make(map[string]bool),
+		lastCommit:  now,
+		lastPush:    now,
+	}
+}
+
+// nextCommit returns when held saves are next committed, or the zero time
+// when there is nothing on a timer.
+func (s *scheduler) nextCommit(conf *Config) time.Time {
+	if len(s.uncommitted) == 0 || conf.commitPolicy() != PolicyInterval {
+		return time.Time{}
+	}
+
+	interval, _ := time.ParseDuration(conf.CommitInterval)
+	return s.lastCommit.Add(interval)
+}
+
+// nextPush returns when unpushed commits are next pushed, or the zero time
+// when there is nothing on a timer.
+func (s *scheduler) nextPush(conf *Config, now time.Time) time.Time {
+	if len(s.unpushed) == 0 {
+		return time.Time{}
+	}
+
+	switch conf.pushPolicy() {
+	case PolicyInterval:
+		interval, _ := time.ParseDuration(conf.PushInterval)
+		return s.lastPush.Add(interval)
+
+	case PolicyAt:
+		var next time.Time
+		for _, at := range conf.PushAt {
+			t, err := parseUntil(at, now)
+			if err == nil && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+		return next
+	}
+
+	return time.Time{}
+}
+
+// after returns a channel that fires at t along with a func that releases the
+// timer. The channel is nil, and never fires, for the zero time.
+func after(clock Clock, t time.Time) (<-chan time.Time, func() bool) {
+	if t.IsZero() {
+		return nil, func() bool { return false }
+	}
+
+	return clock.After(t.Sub(clock.Now()))
+}
+
+func (c *Config) commitPolicy() string {
+	if c.CommitPolicy == "" {
+		return PolicySave
+	}
+
+	return c.CommitPolicy
+}
+
+func (c *Config) pushPolicy() string {
+	if c.PushPolicy == "" {
+		return PolicySave
+	}
+
+	return c.PushPolicy
+}
+
+// validatePolicies checks the commit and push policies and their settings.
+func (c *Config) validatePolicies() []error {
+	errs := make([]error, 0)
+
+	switch c.commitPolicy() {
+	case PolicySave:
+	case PolicyInterval:
+		if err := validInterval(c.CommitInterval); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("CommitInterval"), "CommitInterval: %v", err))
+		}
+	default:
+		errs = append(errs, c.errorf(c.lineOf("CommitPolicy"), "unknown CommitPolicy %q", c.CommitPolicy))
+	}
+
+	switch c.pushPolicy() {
+	case PolicySave, PolicyShutdown:
+	case PolicyInterval:
+		if err := validInterval(c.PushInterval); err != nil {
+			errs = append(errs, c.errorf(c.lineOf("PushInterval"), "PushInterval: %v", err))
+		}
+	case PolicyAt:
+		if len(c.PushAt) == 0 {
+			errs = append(errs, c.errorf(c.lineOf("PushPolicy"), "PushAt is required with PushPolicy %q", PolicyAt))
+		}
+		for _, at := range c.PushAt {
+			if _, err := time.Parse("15:04", at); err != nil {
+				errs = append(errs, c.errorf(c.lineOf("PushAt"), "PushAt: invalid time %q, expected HH:MM", at))
+			}
+		}
+	default:
+		errs = append(errs, c.errorf(c.lineOf("PushPolicy"), "unknown PushPolicy %q", c.PushPolicy))
+	}
+
+	return errs
+}
+
+func validInterval(value string) error {
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Commit signing formats, picked with Config.Sign. gpg signs with
+// SigningKey, or gpg's default key, ssh signs with SigningKey or PrivateKey.
+const (
+	SignGPG = "gpg"
+	SignSSH = "ssh"
+)
+
+const (
+	pgpSignatureHeader = "-----BEGIN PGP SIGNATURE-----"
+	sshSignatureHeader = "-----BEGIN SSH SIGNATURE-----"
+)
+
+// signCommit signs a raw commit object and returns it with the signature
+// added as a gpgsig header, the way git commit -S writes it.
+func (c *Config) signCommit(raw []byte) ([]byte, error) {
+	var sig []byte
+	var err error
+
+	switch c.Sign {
+	case SignGPG:
+		args := []string{"--batch", "--status-fd=2", "--detach-sign", "--armor"}
+		if c.SigningKey != "" {
+			args = append(args, "--local-user", c.SigningKey)
+		}
+		sig, err = pipeCommand(raw, "gpg", args...)
+	case SignSSH:
+		sig, err = pipeCommand(raw, "ssh-keygen", "-Y", "sign", "-n", "git", "-f", c.sshSigningKey())
+	default:
+		return raw, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error signing commit: %v", err)
+	}
+
+	// The signature goes after the last header, continuation lines indented
+	headers, message, _ := bytes.Cut(raw, []byte("\n\n"))
+	header := "gpgsig " + strings.ReplaceAll(strings.TrimSpace(string(sig)), "\n", "\n ")
+
+	signed := make([]byte, 0, len(raw)+len(header)+2)
+	signed = append(signed, headers...)
+	signed = append(signed, '\n')
+	signed = append(signed, header...)
+	signed = append(signed, "\n\n"...)
+	return append(signed, message...), nil
+}
+
+// verifyCommit checks the signature on a raw commit object against the
+// configured key and describes the outcome, e.g. "good ssh signature".
+func (c *Config) verifyCommit(raw []byte) (string, error) {
+	payload, sig := splitSignature(raw)
+	if sig == nil {
+		return "unsigned", nil
+	}
+
+	sigFile, err := writeTemp(sig)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(sigFile)
+
+	switch {
+	case bytes.HasPrefix(sig, []byte(pgpSignatureHeader)):
+		out, err := pipeCommand(payload, "gpg", "--batch", "--status-fd=1", "--verify", sigFile, "-")
+		if err != nil || !bytes.Contains(out, []byte("[GNUPG:] GOODSIG ")) {
+			return "bad gpg signature", nil
+		}
+		return "good gpg signature", nil
+
+	case bytes.HasPrefix(sig, []byte(sshSignatureHeader)):
+		publicKey, err := os.ReadFile(c.sshPublicKey())
+		if err != nil {
+			return "", fmt.Errorf("error reading public key: %v", err)
+		}
+
+		signers, err := writeTemp([]byte(fmt.Sprintf("%s namespaces=\"git\" %s", c.Email, publicKey)))
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(signers)
+
+		if _, err = pipeCommand(payload, "ssh-keygen", "-Y", "verify", "-f", signers, "-I", c.Email, "-n", "git", "-s", sigFile); err != nil {
+			return "bad ssh signature", nil
+		}
+		return "good ssh signature", nil
+	}
+
+	return "unknown signature type", nil
+}
+
+// splitSignature takes the gpgsig header out of a raw commit object. It
+// returns the commit as it was before signing and the signature, which is
+// nil for an unsigned commit.
+func splitSignature(raw []byte) ([]byte, []byte) {
+	headers, message, _ := bytes.Cut(raw, []byte("\n\n"))
+
+	var payload, sig bytes.Buffer
+	inSig := false
+	for _, line := range strings.Split(string(headers), "\n") {
+		switch {
+		case strings.HasPrefix(line, "gpgsig "):
+			inSig = true
+			sig.WriteString(strings.TrimPrefix(line, "gpgsig ") + "\n")
+		case inSig && strings.HasPrefix(line, " "):
+			sig.WriteString(line[1:] + "\n")
+		default:
+			inSig = false
+			payload.WriteString(line + "\n")
+		}
+	}
+	if sig.Len() == 0 {
+		return raw, nil
+	}
+
+	payload.WriteString("\n")
+	payload.Write(message)
+	return payload.Bytes(), sig.Bytes()
+}
+
+func (c *Config) sshSigningKey() string {
+	if c.SigningKey != "" {
+		return c.SigningKey
+	}
+
+	return c.PrivateKey
+}
+
+// sshPublicKey is the key our ssh signatures are checked against.
+func (c *Config) sshPublicKey() string {
+	if c.PublicKey != "" {
+		return c.PublicKey
+	}
+
+	return strings.TrimSuffix(c.sshSigningKey(), ".pub") + ".pub"
+}
+
+// pipeCommand runs a command like gpg or age with data on stdin and returns
+// what it wrote to stdout.
+func pipeCommand(data []byte, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func writeTemp(data []byte) (string, error) {
+	f, err := os.CreateTemp("", "gistbot-")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing temp file: %v", err)
+	}
+
+	return f.Name(), nil
+}
+
+// validateSigning checks the commit signing settings.
+func (c *Config) validateSigning() []error {
+	errs := make([]error, 0)
+
+	switch c.Sign {
+	case "", SignGPG:
+	case SignSSH:
+		if c.sshSigningKey() == "" {
+			errs = append(errs, c.errorf(c.lineOf("Sign"), "SigningKey or PrivateKey is required with Sign %q", SignSSH))
+		}
+	default:
+		errs = append(errs, c.errorf(c.lineOf("Sign"), "unknown Sign %q", c.Sign))
+	}
+
+	return errs
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Squash folds the auto-commits made since the last push into one commit
+// with a combined message. Only commits that are not on origin/master are
+// rewritten, so origin is fetched first to be sure of what it holds. Nothing
+// is done unless every local commit is an auto-commit.
+func (r *Repository) Squash() error {
+	if err := r.git.Fetch(); err != nil {
+		return fmt.Errorf("error fetching before squash: %v", err)
+	}
+
+	commits, err := r.git.LocalCommits()
+	if err != nil {
+		return err
+	}
+	if len(commits) < 2 {
+		return nil
+	}
+
+	for _, commit := range commits {
+		if commit.Parents != 1 || !strings.HasPrefix(commit.Summary, commitMessage) {
+			r.log.Debug("not squashing, local history has commits not made by the bot", "commit", commit.Id)
+			return nil
+		}
+	}
+
+	// Keep the author of the oldest commit, the squash is committed now
+	author := commits[len(commits)-1].Author
+	commitId, err := r.git.Squash(r.message(squashMessage(commits)), &author, r.committer())
+	if err != nil {
+		return err
+	}
+
+	r.log.Info("squashed auto-commits", "commits", len(commits), "commit", commitId)
+	return nil
+}
+
+// squashMessage lists the squashed commits, oldest first, under one summary.
+func squashMessage(commits []CommitInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%d changes)\n\n", commitMessage, len(commits))
+
+	for i := len(commits) - 1; i >= 0; i-- {
+		when := commits[i].Author.When.Format(time.RFC3339)
+		fmt.Fprintf(&b, "* %s %s\n", when, commits[i].Summary)
+	}
+
+	return b.String()
+}
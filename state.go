@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// State is what the bot remembers across restarts. It lives in a JSON file
+// shared by the daemon and the command line, so every change goes through
+// UpdateState which holds a lock while it reads, modifies and writes it.
+type State struct {
+	Global PauseState
+	Repos  map[string]*RepoState
+}
+
+type PauseState struct {
+	Paused bool
+	// ResumeAt is when a pause ends by itself, zero pauses until resumed
+	ResumeAt time.Time
+}
+
+type RepoState struct {
+	PauseState
+	// Pending is set when a change was held back while paused
+	Pending bool
+	// NeedsAttention says why the repository could not be synced
+	// automatically, empty when it is fine
+	NeedsAttention string `json:",omitempty"`
+	// Copies holds the checksum of each file copied into place, by target
+	Copies map[string]string `json:",omitempty"`
+	// Mirrored holds the checksum of each mirrored file as of its last
+	// sync, by the path of the real file
+	Mirrored map[string]string `json:",omitempty"`
+	// LastPush is when the bot last pushed the repository
+	LastPush time.Time `json:",omitzero"`
+	// LastError is the last commit, pull or push failure, cleared by the
+	// next sync that goes through
+	LastError string `json:",omitempty"`
+}
+
+// stateFile returns the path of the state file: StateFile from the config or
+// $XDG_STATE_HOME/gistbot/state.json.
+func stateFile(conf *Config) string {
+	if conf.StateFile != "" {
+		return conf.StateFile
+	}
+
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = expandPath("~/.local/state")
+	}
+
+	return filepath.Join(dir, "gistbot", "state.json")
+}
+
+// LoadState reads the state file. A missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	state := State{Repos: make(map[string]*RepoState)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state: %v", err)
+	}
+
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	if state.Repos == nil {
+		state.Repos = make(map[string]*RepoState)
+	}
+
+	return &state, nil
+}
+
+// UpdateState applies fn to the state on disk and writes it back. Nothing is
+// written if fn returns an error.
+func UpdateState(path string, fn func(*State) error) (*State, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening state lock: %v", err)
+	}
+	defer lock.Close()
+
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("error locking state: %v", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	state, err := LoadState(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = fn(state); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding state: %v", err)
+	}
+
+	// Write to a temporary file and rename it so readers never see half a file
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, fmt.Errorf("error writing state: %v", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("error writing state: %v", err)
+	}
+
+	return state, nil
+}
+
+// Repo returns the state of the repository at path, creating it if needed.
+func (s *State) Repo(path string) *RepoState {
+	repo, ok := s.Repos[path]
+	if !ok {
+		repo = &RepoState{}
+		s.Repos[path] = repo
+	}
+
+	return repo
+}
+
+// IsPaused reports whether auto-commit is paused for the repository at path,
+// either on its own or globally.
+func (s *State) IsPaused(path string, now time.Time) bool {
+	if s.Global.active(now) {
+		return true
+	}
+
+	repo, ok := s.Repos[path]
+	return ok && repo.active(now)
+}
+
+// Expire clears pauses whose resume time has passed and reports whether any
+// were cleared.
+func (s *State) Expire(now time.Time) bool {
+	expired := s.Global.expire(now)
+	for _, repo := range s.Repos {
+		if repo.expire(now) {
+			expired = true
+		}
+	}
+
+	return expired
+}
+
+func (p *PauseState) active(now time.Time) bool {
+	return p.Paused && (p.ResumeAt.IsZero() || now.Before(p.ResumeAt))
+}
+
+func (p *PauseState) expire(now time.Time) bool {
+	if p.Paused && !p.ResumeAt.IsZero() && !now.Before(p.ResumeAt) {
+		*p = PauseState{}
+		return true
+	}
+
+	return false
+}
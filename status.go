@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RepoStatus is what the status command reports on one repository. Ahead
+// and behind are counted against origin/master as of the last fetch.
+type RepoStatus struct {
+	Repo       string    `json:"repo"`
+	Paused     bool      `json:"paused"`
+	Ahead      int       `json:"ahead"`
+	Behind     int       `json:"behind"`
+	Dirty      []string  `json:"dirty"`
+	LastCommit time.Time `json:"last_commit,omitzero"`
+	LastPush   time.Time `json:"last_push,omitzero"`
+	LastError  string    `json:"last_error,omitempty"`
+	Signature  string    `json:"signature,omitempty"`
+	Links      []Link    `json:"links,omitempty"`
+	Attention  string    `json:"attention,omitempty"`
+	// Error is set when the repository could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// statusCommand prints what the bot knows about each repository, as a table
+// or as JSON for scripts.
+func statusCommand(conf *Config, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the status as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s status [-json]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	repos, err := NewFinder(conf).Find()
+	if err != nil {
+		return fmt.Errorf("error finding repos %v", err)
+	}
+
+	state, err := LoadState(stateFile(conf))
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]*RepoStatus, 0, len(repos))
+	for _, path := range repos {
+		statuses = append(statuses, repoStatus(conf, state, path, time.Now()))
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+
+	return printStatus(statuses)
+}
+
+// repoStatus gathers the status of the repository at path from git and the
+// state file.
+func repoStatus(conf *Config, state *State, path string, now time.Time) *RepoStatus {
+	status := &RepoStatus{Repo: path, Paused: state.IsPaused(path, now), Dirty: []string{}}
+
+	var copies map[string]string
+	if s := state.Repos[path]; s != nil {
+		copies = s.Copies
+		status.LastPush = s.LastPush
+		status.LastError = s.LastError
+		status.Attention = s.NeedsAttention
+	}
+	status.Links = LinkHealth(conf, path, copies)
+
+	repo, err := NewRepository(conf, path)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	sync, err := repo.Status()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Ahead, status.Behind = sync.Ahead, sync.Behind
+	status.Dirty = sync.Dirty
+	status.LastCommit = sync.LastCommit
+
+	if status.Signature, err = repo.Verify(); err != nil {
+		status.Signature = err.Error()
+	}
+
+	return status
+}
+
+func printStatus(statuses []*RepoStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tAHEAD\tBEHIND\tDIRTY\tLAST COMMIT\tLAST PUSH\tPAUSED\tSIGNATURE\tLINKS\tATTENTION\tLAST ERROR")
+
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\t%t\t-\t%s\t%s\t%s\n", s.Repo, timeLabel(s.LastPush), s.Paused, linkSummary(s.Links), orDash(s.Attention), oneLine(s.Error))
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n",
+			s.Repo, s.Ahead, s.Behind, len(s.Dirty), timeLabel(s.LastCommit), timeLabel(s.LastPush),
+			s.Paused, s.Signature, linkSummary(s.Links), orDash(s.Attention), orDash(oneLine(s.LastError)))
+	}
+
+	return w.Flush()
+}
+
+func timeLabel(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// oneLine keeps a multi-line error, like git's stderr, on its table row.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
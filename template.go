@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"text/template"
+)
+
+// TemplateFacts is what a .tmpl file is rendered with, e.g.
+// {{if eq .Hostname "work-laptop"}}.
+type TemplateFacts struct {
+	Hostname string
+	OS       string
+	Arch     string
+	Username string
+	Home     string
+	// Vars are the Vars of the config, overridden by those of the repository
+	Vars map[string]string
+}
+
+// templateFacts gathers the facts about this machine for the repository at
+// path.
+func (c *Config) templateFacts(path string) *TemplateFacts {
+	facts := &TemplateFacts{OS: runtime.GOOS, Arch: runtime.GOARCH, Vars: maps.Clone(c.Vars)}
+
+	facts.Hostname, _ = os.Hostname()
+	facts.Home, _ = os.UserHomeDir()
+	if u, err := user.Current(); err == nil {
+		facts.Username = u.Username
+	}
+
+	if facts.Vars == nil {
+		facts.Vars = make(map[string]string)
+	}
+	if repo := c.repoConfig(path); repo != nil {
+		maps.Copy(facts.Vars, repo.Vars)
+	}
+
+	return facts
+}
+
+// renderTemplate renders the template file at path. A missing variable is an
+// error rather than an empty string.
+func renderTemplate(path string, facts *TemplateFacts) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, facts); err != nil {
+		return nil, fmt.Errorf("error rendering template: %v", err)
+	}
+
+	return out.Bytes(), nil
+}
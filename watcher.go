@@ -2,14 +2,29 @@ package main
 
 import (
 	"fmt"
-	"github.com/fsnotify/fsnotify"
-	"log"
+	"log/slog"
+	"path/filepath"
 	"regexp"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
 )
 
+// EventSource reports saves in the watched repositories to the bot. Watcher is
+// the fsnotify implementation.
+type EventSource interface {
+	AddWatches(paths []string) []error
+	Remove(path string) error
+	SetConfig(conf *Config)
+	Watch(events chan string, errors chan error)
+	Close() error
+}
+
 type Watcher struct {
 	Conf    *Config
 	Watcher *fsnotify.Watcher
+
+	mu sync.RWMutex
 }
 
 func NewWatcher(conf *Config) (*Watcher, error) {
@@ -37,10 +52,31 @@ func (w *Watcher) Add(path string) error {
 		return fmt.Errorf("error adding watcher to (%s): %v", path, err)
 	}
 
-	log.Printf("watching: %s\n", path)
+	slog.Info("watching", "repo", path)
 	return nil
 }
 
+func (w *Watcher) Remove(path string) error {
+	if err := w.Watcher.Remove(path); err != nil {
+		return fmt.Errorf("error removing watcher from (%s): %v", path, err)
+	}
+
+	slog.Info("stopped watching", "repo", path)
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	return w.Watcher.Close()
+}
+
+// SetConfig swaps the config used to filter events.
+func (w *Watcher) SetConfig(conf *Config) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.Conf = conf
+}
+
 func (w *Watcher) Watch(events chan string, errors chan error) {
 	for {
 		select {
@@ -50,8 +86,8 @@ func (w *Watcher) Watch(events chan string, errors chan error) {
 			switch event.Op {
 
 			case fsnotify.Write:
-				if !w.isReservedGitPath(event.Name) {
-					log.Printf("save: %s", event.Name)
+				if !w.isReservedGitPath(event.Name) && !w.isIgnored(event.Name) {
+					slog.Debug("file saved", "repo", filepath.Dir(event.Name), "file", event.Name)
 					events <- event.Name
 				}
 			}
@@ -68,3 +104,22 @@ func (w *Watcher) isReservedGitPath(path string) bool {
 
 	return exp.MatchString(path)
 }
+
+// isIgnored reports whether the file name matches one of the Ignore patterns.
+func (w *Watcher) isIgnored(path string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	name := filepath.Base(path)
+	// Ciphertext is written by the bot as it commits
+	if w.Conf.isCiphertext(name) {
+		return true
+	}
+	for _, pattern := range w.Conf.Ignore {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
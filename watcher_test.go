@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// fakeWatcher is an EventSource driven by the test: save writes a file and
+// reports it to the bot the way fsnotify would.
+type fakeWatcher struct {
+	saves chan string
+	done  chan struct{}
+
+	mu      sync.Mutex
+	watched map[string]bool
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{saves: make(chan string), done: make(chan struct{}), watched: make(map[string]bool)}
+}
+
+func (w *fakeWatcher) AddWatches(paths []string) []error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, path := range paths {
+		w.watched[path] = true
+	}
+
+	return nil
+}
+
+func (w *fakeWatcher) Remove(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.watched, path)
+	return nil
+}
+
+func (w *fakeWatcher) SetConfig(conf *Config) {}
+
+func (w *fakeWatcher) Watch(events chan string, errors chan error) {
+	for {
+		select {
+		case path := <-w.saves:
+			events <- path
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *fakeWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+// save writes content to path and reports the save.
+func (w *fakeWatcher) save(t *testing.T, path, content string) {
+	t.Helper()
+
+	writeFile(t, path, content)
+	w.saves <- path
+}
+
+func (w *fakeWatcher) isWatched(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.watched[path]
+}
+
+func TestIsReservedGitPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/gists/a/.git/index", true},
+		{"/gists/a/.git/refs/heads/master", true},
+		{"/gists/a/.git", true},
+		{"/gists/a/.gitignore", true},
+		{"/gists/a/.notes.txt.swp", true},
+		{"/gists/a/notes.txt", false},
+		{"/gists/a/.bashrc", false},
+		{"/gists/a/git.txt", false},
+	}
+
+	w := &Watcher{Conf: &Config{}}
+	for _, tt := range tests {
+		if got := w.isReservedGitPath(tt.path); got != tt.want {
+			t.Errorf("isReservedGitPath(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}